@@ -4,6 +4,9 @@ go 1.21.3
 
 require (
 	github.com/atotto/clipboard v0.1.4
+	golang.org/x/crypto v0.17.0
 	golang.org/x/exp v0.0.0-20231006140011-7918f672742d
+	golang.org/x/net v0.19.0
+	golang.org/x/sys v0.15.0
 	k8s.io/apimachinery v0.28.3
 )
@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	progressBarWidth = 20
+	// How often progress is logged when not writing to a terminal.
+	progressLogInterval = 5 * time.Second
+)
+
+// Progress of a batch of files, drawn as a bar on terminals and logged as a line every few seconds otherwise, such
+// as when redirected to a file. Methods of a nil bar do nothing, so callers don't have to check whether it's shown.
+type progressBar struct {
+	file        *os.File
+	terminal    bool
+	total, done int
+	start       time.Time
+	lastLog     time.Time
+}
+
+func newProgressBar(file *os.File, total int) *progressBar {
+	info, err := file.Stat()
+	terminal := err == nil && info.Mode()&os.ModeCharDevice != 0
+	bar := &progressBar{file: file, terminal: terminal, total: total, start: time.Now()}
+	bar.draw()
+	return bar
+}
+
+// Count a file as done.
+func (p *progressBar) Increment() {
+	if p == nil {
+		return
+	}
+	p.done++
+	if p.terminal || p.done == p.total || time.Since(p.lastLog) >= progressLogInterval {
+		p.draw()
+	}
+}
+
+// Clear the bar, for output to the same terminal to not end up after it. It's drawn again by `Increment()`.
+func (p *progressBar) Clear() {
+	if p == nil || !p.terminal {
+		return
+	}
+	fmt.Fprintf(p.file, "\r%60s\r", "")
+}
+
+// End the bar's line, or log where it stopped when that's short of the total.
+func (p *progressBar) Finish() {
+	if p == nil {
+		return
+	}
+	if p.terminal {
+		fmt.Fprintln(p.file)
+	} else if p.done != p.total {
+		p.draw()
+	}
+}
+
+func (p *progressBar) draw() {
+	eta := "--"
+	if p.done > 0 {
+		remaining := time.Since(p.start) / time.Duration(p.done) * time.Duration(p.total-p.done)
+		eta = remaining.Round(time.Second).String()
+	}
+	status := fmt.Sprintf("%d/%d files (ETA %s)", p.done, p.total, eta)
+
+	if !p.terminal {
+		p.lastLog = time.Now()
+		fmt.Fprintln(p.file, status)
+		return
+	}
+	filled := progressBarWidth
+	if p.total > 0 {
+		filled = progressBarWidth * p.done / p.total
+	}
+	// Padded, as the previous line may have been longer.
+	line := fmt.Sprintf("[%s%s] %s", strings.Repeat("#", filled), strings.Repeat(" ", progressBarWidth-filled), status)
+	fmt.Fprintf(p.file, "\r%-60s", line)
+}
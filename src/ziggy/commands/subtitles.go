@@ -11,14 +11,12 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
-	"slices"
 	"strings"
 
 	"uosc/bins/src/ziggy/lib"
 )
 
-const OPEN_SUBTITLES_API_URL = "https://api.opensubtitles.com/api/v1"
+const OPEN_SUBTITLES_API_URL = lib.OSDB_API_URL
 
 type DownloadRequestData struct {
 	FileId int `json:"file_id"`
@@ -66,27 +64,27 @@ func SearchSubtitles(args []string) {
 		lib.Check(errors.New("--languages is required"))
 	}
 
-	// "Send request parameters sorted, and send all queries in lowercase."
-	params := []string{}
-	languageDelimiterRE := regexp.MustCompile(" *, *")
-	languages := languageDelimiterRE.Split(*argLanguages, -1)
-	slices.Sort(languages)
-	params = append(params, "languages="+escapeParam(strings.Join(languages, ",")))
+	hash := ""
+	var fileSize int64
 	if len(*argHash) > 0 {
-		hash, err := lib.OSDBHashFile(*argHash)
+		result, err := lib.OSDBHashFileResult(*argHash)
 		if err == nil {
-			params = append(params, "moviehash="+escapeParam(hash))
+			hash, fileSize = result.Hash, result.FileSize
 		} else if len(*argQuery) == 0 {
 			lib.Check(fmt.Errorf("couldn't hash the file (%w) and query is empty", err))
 		}
 	}
-	params = append(params, "page="+escapeParam(fmt.Sprint(*argPage)))
+	// "Send request parameters sorted, and send all queries in lowercase."
+	searchURL := lib.Must(url.Parse(lib.OpenSubtitlesSearchURL(hash, fileSize, *argLanguages)))
+	params := searchURL.Query()
+	params.Set("page", fmt.Sprint(*argPage))
 	if len(*argQuery) > 0 {
-		params = append(params, "query="+escapeParam(*argQuery))
+		params.Set("query", strings.ToLower(*argQuery))
 	}
+	searchURL.RawQuery = params.Encode()
 
 	client := http.Client{}
-	req := lib.Must(http.NewRequest("GET", OPEN_SUBTITLES_API_URL+"/subtitles?"+strings.Join(params, "&"), nil))
+	req := lib.Must(http.NewRequest("GET", searchURL.String(), nil))
 	req.Header = http.Header{
 		"Api-Key":    {*argApiKey},
 		"User-Agent": {*argAgent},
@@ -168,8 +166,3 @@ func DownloadSubtitles(args []string) {
 		ResetTime: downloadData.ResetTime,
 	}))))
 }
-
-// Escape and lowercase (open subtitles requirement) a URL parameter
-func escapeParam(str string) string {
-	return url.QueryEscape(strings.ToLower(str))
-}
@@ -0,0 +1,121 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"uosc/bins/src/ziggy/lib"
+)
+
+const (
+	verifyOK      = "OK"
+	verifyFail    = "FAIL"
+	verifyMissing = "MISSING"
+)
+
+type VerifyResult struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	// Why a file failed, when it couldn't be hashed or its manifest line is malformed.
+	Message string `json:"message,omitempty"`
+}
+
+// Verify files listed in a manifest read from stdin, printing a `VerifyResult` JSON line for each of them.
+// Lines are either `path\thash` with an OSDB hash, or `hash  path` as printed by `sha256sum`.
+func VerifyHashes(args []string) {
+	cmd := flag.NewFlagSet("verify-hashes", flag.ExitOnError)
+	argProgress := cmd.Bool("progress", false, "Show progress on stderr. A bar on terminals, a line every few seconds otherwise.")
+
+	lib.Check(cmd.Parse(args))
+
+	// The whole manifest is read first to know how many files there are.
+	input := io.Reader(os.Stdin)
+	var progress *progressBar
+	if *argProgress {
+		manifest, err := io.ReadAll(os.Stdin)
+		lib.Check(err)
+		input = bytes.NewReader(manifest)
+		progress = newProgressBar(os.Stderr, countManifestEntries(manifest))
+	}
+
+	err := verifyManifest(input, os.Stdout, progress)
+	progress.Finish()
+	lib.Check(err)
+}
+
+func countManifestEntries(manifest []byte) (count int) {
+	for _, line := range strings.Split(string(manifest), "\n") {
+		if strings.TrimSpace(line) != "" {
+			count++
+		}
+	}
+	return count
+}
+
+func verifyManifest(r io.Reader, w io.Writer, progress *progressBar) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		result := VerifyResult{Status: verifyFail, Message: "manifest line has no hash"}
+		if filePath, hash, ok := parseManifestLine(line); ok {
+			result = verifyManifestEntry(filePath, hash)
+		} else {
+			result.Path = filePath
+		}
+		json, err := lib.JSONMarshal(result)
+		if err != nil {
+			return err
+		}
+		progress.Clear()
+		if _, err := w.Write(json); err != nil {
+			return err
+		}
+		progress.Increment()
+	}
+	return scanner.Err()
+}
+
+// Manifest lines with a tab are `path\thash`, others are `sha256sum` lines, where `*` marks binary mode.
+func parseManifestLine(line string) (filePath, hash string, ok bool) {
+	if path, hash, found := strings.Cut(line, "\t"); found {
+		return path, strings.TrimSpace(hash), true
+	}
+	hash, path, found := strings.Cut(line, " ")
+	if !found {
+		return line, "", false
+	}
+	path = strings.TrimPrefix(strings.TrimPrefix(path, " "), "*")
+	return path, hash, true
+}
+
+func verifyManifestEntry(filePath, hash string) VerifyResult {
+	result := VerifyResult{Path: filePath, Status: verifyFail}
+	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
+		result.Status = verifyMissing
+		return result
+	}
+
+	var match bool
+	var err error
+	if len(hash) == 64 {
+		var actual string
+		actual, err = lib.SHA256HashFile(filePath)
+		match = err == nil && strings.EqualFold(actual, hash)
+	} else {
+		match, err = lib.OSDBVerifyFile(filePath, hash)
+	}
+	if err != nil {
+		result.Message = err.Error()
+	} else if match {
+		result.Status = verifyOK
+	}
+	return result
+}
@@ -0,0 +1,10 @@
+package commands
+
+import (
+	"fmt"
+	"uosc/bins/src/ziggy/lib"
+)
+
+func Version(_ []string) {
+	fmt.Print(string(lib.Must(lib.JSONMarshal(lib.GetBuildInfo()))))
+}
@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type auditLogEntry struct {
+	Timestamp  string `json:"ts"`
+	Path       string `json:"path"`
+	Hash       string `json:"hash"`
+	User       string `json:"user"`
+	PID        int    `json:"pid"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+var auditLogMutex sync.Mutex
+
+// Append an entry for a hash operation to the audit log at `opts.AuditLog`, failed operations are logged too.
+func writeAuditLog(opts Options, filePath string, hash string, duration time.Duration, hashErr error) error {
+	user := opts.AuditUser
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	if user == "" {
+		// Windows
+		user = os.Getenv("USERNAME")
+	}
+
+	entry := auditLogEntry{
+		Timestamp:  time.Now().UTC().Format(time.RFC3339Nano),
+		Path:       filePath,
+		Hash:       hash,
+		User:       user,
+		PID:        os.Getpid(),
+		DurationMs: duration.Milliseconds(),
+	}
+	if hashErr != nil {
+		entry.Error = hashErr.Error()
+	}
+	line, err := JSONMarshal(entry)
+	if err != nil {
+		return err
+	}
+
+	auditLogMutex.Lock()
+	defer auditLogMutex.Unlock()
+	file, err := os.OpenFile(opts.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("couldn't open audit log: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(line); err != nil {
+		return fmt.Errorf("couldn't write audit log: %w", err)
+	}
+	return nil
+}
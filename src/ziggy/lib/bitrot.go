@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Rehash every file in `knownHashes`, a map of paths relative to `dir` (or absolute) to their stored OSDB hashes,
+// and return the paths whose hash no longer matches, sorted. Files are hashed by `concurrency` workers.
+//
+// Files that can't be hashed don't stop the scan, their errors are joined and returned along with the corrupted
+// paths found, unless `WithFailFast()` is set, in which case the scan stops at the first error.
+func BitRotScan(dir string, knownHashes map[string]string, concurrency int, opts ...Option) (corrupted []string, err error) {
+	options := newOptions(opts)
+
+	paths := make(chan string)
+	var (
+		mutex sync.Mutex
+		errs  []error
+		stop  = make(chan struct{})
+		once  sync.Once
+		wg    sync.WaitGroup
+	)
+	fail := func(err error) {
+		mutex.Lock()
+		errs = append(errs, err)
+		mutex.Unlock()
+		if options.FailFast {
+			once.Do(func() { close(stop) })
+		}
+	}
+
+	for i := 0; i < max(concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				filePath := path
+				if !filepath.IsAbs(filePath) {
+					filePath = filepath.Join(dir, filePath)
+				}
+
+				start := time.Now()
+				ok, err := OSDBVerifyFile(filePath, knownHashes[path], opts...)
+				slog.Debug("bit rot check", "path", filePath, "ok", ok, "duration", time.Since(start), "error", err)
+				if err != nil {
+					fail(fmt.Errorf("%s: %w", path, err))
+					continue
+				}
+				if !ok {
+					mutex.Lock()
+					corrupted = append(corrupted, path)
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+
+feed:
+	for path := range knownHashes {
+		select {
+		case paths <- path:
+		case <-stop:
+			break feed
+		}
+	}
+	close(paths)
+	wg.Wait()
+
+	sort.Strings(corrupted)
+	if options.FailFast && len(errs) > 0 {
+		return corrupted, errs[0]
+	}
+	return corrupted, errors.Join(errs...)
+}
@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("too many consecutive failures, not sending requests")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+type circuitBreakerChunkReader struct {
+	inner      ChunkReader
+	threshold  int
+	resetAfter time.Duration
+
+	mutex    sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// Wrap a reader so that it fails fast with `ErrCircuitOpen` after `threshold` consecutive failed reads.
+// After `resetAfter` a single probe read is let through, closing the circuit when it succeeds,
+// and waiting another `resetAfter` when it doesn't.
+func NewCircuitBreakerChunkReader(inner ChunkReader, threshold int, resetAfter time.Duration) ChunkReader {
+	return &circuitBreakerChunkReader{inner: inner, threshold: max(threshold, 1), resetAfter: resetAfter}
+}
+
+func (r *circuitBreakerChunkReader) Size() (size int64, err error) {
+	if err := r.allow(); err != nil {
+		return 0, err
+	}
+	size, err = r.inner.Size()
+	r.record(err)
+	return size, err
+}
+
+func (r *circuitBreakerChunkReader) ReadChunk(offset int64, buf []byte) error {
+	if err := r.allow(); err != nil {
+		return err
+	}
+	err := r.inner.ReadChunk(offset, buf)
+	r.record(err)
+	return err
+}
+
+func (r *circuitBreakerChunkReader) Close() error {
+	if closer, ok := r.inner.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (r *circuitBreakerChunkReader) allow() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	switch r.state {
+	case circuitOpen:
+		if time.Since(r.openedAt) < r.resetAfter {
+			return ErrCircuitOpen
+		}
+		r.state = circuitHalfOpen
+		return nil
+	case circuitHalfOpen:
+		// Probe is already in flight
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+func (r *circuitBreakerChunkReader) record(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err == nil {
+		r.state, r.failures = circuitClosed, 0
+		return
+	}
+	r.failures++
+	if r.state == circuitHalfOpen || r.failures >= r.threshold {
+		r.state, r.openedAt = circuitOpen, time.Now()
+	}
+}
@@ -0,0 +1,162 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ChunkReader provides random access to a hashable source.
+// Readers that hold resources can also implement `io.Closer`, and will be closed once hashing is done.
+type ChunkReader interface {
+	// Total size of the source in bytes.
+	Size() (int64, error)
+	// Fill the whole `buf` with bytes starting at `offset`.
+	ReadChunk(offset int64, buf []byte) error
+}
+
+type ChunkReaderFactory func(url string, opts Options) (ChunkReader, error)
+
+var (
+	schemesMutex sync.RWMutex
+	schemes      = map[string]ChunkReaderFactory{}
+)
+
+// Register a factory for URLs in `scheme://` format, such as `s3` or `ipfs`.
+// Registered schemes take precedence over the built-in `http(s)://` and file path handling.
+func RegisterURLScheme(scheme string, factory func(url string, opts Options) (ChunkReader, error)) {
+	schemesMutex.Lock()
+	defer schemesMutex.Unlock()
+	schemes[strings.ToLower(scheme)] = factory
+}
+
+func lookupURLScheme(url string) (factory ChunkReaderFactory, ok bool) {
+	// Not using `url.Parse()` as it'd treat windows drive letters as schemes.
+	scheme, _, found := strings.Cut(url, "://")
+	if !found {
+		return nil, false
+	}
+
+	schemesMutex.RLock()
+	defer schemesMutex.RUnlock()
+	factory, ok = schemes[strings.ToLower(scheme)]
+	return factory, ok
+}
+
+// Read chunks from `reader` of the URL `source`, or of an empty source for readers of local or in-memory data.
+func readReaderChunks(reader ChunkReader, source string, opts Options, minimumRequiredSize int64, chunks ...chunkInfo) (fileSize int64, buf []byte, err error) {
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	fileSize, err = reader.Size()
+	if err != nil {
+		return
+	}
+
+	if fileSize < minimumRequiredSize {
+		err = errors.New("file is too small to generate a valid hash")
+		return
+	}
+
+	fill := fillChunks
+	if opts.DeterministicOrder {
+		fill = fillChunksInOrder
+	}
+	buf, err = fill(opts.buffer, fileSize, chunks, limitHostReads(opts, source, cancellableReads(optionsContext(opts), reader.ReadChunk)))
+	return fileSize, buf, err
+}
+
+// Wrap a chunk read so it fails without reading once `ctx` is done.
+func cancellableReads(ctx context.Context, read func(offset int64, buf []byte) error) func(offset int64, buf []byte) error {
+	return func(offset int64, buf []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return read(offset, buf)
+	}
+}
+
+type chunkRegion struct {
+	offset int64
+	buf    []byte
+}
+
+// Lay out a buffer for all `chunks`, allocating it unless `into` is big enough to hold them.
+// Negative chunk offsets are relative to the end of the file.
+func layoutChunks(into []byte, fileSize int64, chunks []chunkInfo) (buf []byte, regions []chunkRegion) {
+	totalBufferNeeded := int64(0)
+	for _, span := range chunks {
+		totalBufferNeeded += span.size
+	}
+
+	if int64(len(into)) >= totalBufferNeeded {
+		buf = into[:totalBufferNeeded]
+	} else {
+		buf = make([]byte, totalBufferNeeded)
+	}
+	filled := 0
+	for _, span := range chunks {
+		start := span.offset
+		if start < 0 {
+			start += fileSize
+		}
+		regions = append(regions, chunkRegion{start, buf[filled : filled+int(span.size)]})
+		filled += int(span.size)
+	}
+	return buf, regions
+}
+
+// Fill a buffer for all `chunks` using `read`, one chunk after another.
+func fillChunks(into []byte, fileSize int64, chunks []chunkInfo, read func(offset int64, buf []byte) error) (buf []byte, err error) {
+	buf, regions := layoutChunks(into, fileSize, chunks)
+	for _, region := range regions {
+		err = read(region.offset, region.buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// Same as `fillChunks()`, but chunks are read in order of their offset in the file rather than the order they're
+// listed in. Chunks keep their place in the buffer.
+func fillChunksInOrder(into []byte, fileSize int64, chunks []chunkInfo, read func(offset int64, buf []byte) error) (buf []byte, err error) {
+	buf, regions := layoutChunks(into, fileSize, chunks)
+	sort.SliceStable(regions, func(i, j int) bool {
+		return regions[i].offset < regions[j].offset
+	})
+	for _, region := range regions {
+		err = read(region.offset, region.buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// Same as `fillChunks()`, but all chunks are read at the same time. Each chunk has its own place in the buffer,
+// so the result is laid out the same regardless of which read finishes first.
+func fillChunksConcurrently(into []byte, fileSize int64, chunks []chunkInfo, read func(offset int64, buf []byte) error) (buf []byte, err error) {
+	buf, regions := layoutChunks(into, fileSize, chunks)
+	errs := make([]error, len(regions))
+	var wg sync.WaitGroup
+	for i, region := range regions {
+		wg.Add(1)
+		go func(i int, region chunkRegion) {
+			defer wg.Done()
+			errs[i] = read(region.offset, region.buf)
+		}(i, region)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"uosc/bins/src/ziggy/lib/testutil"
+)
+
+func TestFillChunks(t *testing.T) {
+	data := readSyntheticFile(t, 10000)
+	chunks := []chunkInfo{{0, 100}, {-100, 100}, {5000, 10}, {50, 100}}
+	expected := bytes.Join([][]byte{data[:100], data[9900:], data[5000:5010], data[50:150]}, nil)
+
+	tests := []struct {
+		name string
+		fill func(into []byte, fileSize int64, chunks []chunkInfo, read func(offset int64, buf []byte) error) ([]byte, error)
+		// Offsets chunks have to be read in, or nil when any order goes.
+		order []int64
+	}{
+		{"as listed", fillChunks, []int64{0, 9900, 5000, 50}},
+		{"in order", fillChunksInOrder, []int64{0, 50, 5000, 9900}},
+		{"concurrently", fillChunksConcurrently, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, into := range [][]byte{nil, make([]byte, 10), make([]byte, 1000)} {
+				var mutex sync.Mutex
+				var order []int64
+				buf, err := tt.fill(into, int64(len(data)), chunks, func(offset int64, buf []byte) error {
+					mutex.Lock()
+					order = append(order, offset)
+					mutex.Unlock()
+					return bytesChunkReader(data).ReadChunk(offset, buf)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(buf, expected) {
+					t.Errorf("buffer doesn't have the chunks in their listed order")
+				}
+				if len(into) >= len(expected) && &buf[0] != &into[0] {
+					t.Errorf("buffer of %d bytes wasn't used", len(into))
+				}
+				if tt.order != nil && len(order) != len(tt.order) {
+					t.Fatalf("read %v, expected %v", order, tt.order)
+				}
+				for i := range tt.order {
+					if order[i] != tt.order[i] {
+						t.Fatalf("read %v, expected %v", order, tt.order)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("read failure", func(t *testing.T) {
+		readErr := errors.New("read failed")
+		for _, fill := range []func([]byte, int64, []chunkInfo, func(int64, []byte) error) ([]byte, error){
+			fillChunks, fillChunksInOrder, fillChunksConcurrently,
+		} {
+			_, err := fill(nil, int64(len(data)), chunks, func(offset int64, buf []byte) error {
+				if offset == 5000 {
+					return readErr
+				}
+				return nil
+			})
+			if !errors.Is(err, readErr) {
+				t.Errorf("error is %v, expected %v", err, readErr)
+			}
+		}
+	})
+}
+
+func TestRegisterURLScheme(t *testing.T) {
+	const size = 300000
+	data := readSyntheticFile(t, size)
+	RegisterURLScheme("Test-Mem", func(url string, opts Options) (ChunkReader, error) {
+		if url != "test-mem://movie.mkv" && url != "TEST-MEM://movie.mkv" {
+			return nil, errors.New("not found")
+		}
+		return bytesChunkReader(data), nil
+	})
+	if err := testutil.ValidateChunkReader(bytesChunkReader(data), data); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: "test-mem://movie.mkv"},
+		{url: "TEST-MEM://movie.mkv"},
+		{url: "test-mem://other.mkv", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			hash, err := OSDBHashFile(tt.url)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("succeeded with %s", hash)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := testutil.KnownHash(size); hash != expected {
+				t.Errorf("hash is %s, expected %s", hash, expected)
+			}
+		})
+	}
+}
+
+func TestCancellableReads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	reads := 0
+	read := cancellableReads(ctx, func(offset int64, buf []byte) error {
+		reads++
+		return nil
+	})
+	if err := read(0, nil); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := read(0, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("error is %v, expected context.Canceled", err)
+	}
+	if reads != 1 {
+		t.Errorf("read %d times after cancelling, expected once before", reads)
+	}
+}
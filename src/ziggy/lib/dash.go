@@ -0,0 +1,365 @@
+package lib
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type mpdSegmentTemplate struct {
+	Media          string `xml:"media,attr"`
+	StartNumber    *int64 `xml:"startNumber,attr"`
+	Duration       int64  `xml:"duration,attr"`
+	Timescale      *int64 `xml:"timescale,attr"`
+	PresentationTO int64  `xml:"presentationTimeOffset,attr"`
+	Timeline       []struct {
+		T *int64 `xml:"t,attr"`
+		D int64  `xml:"d,attr"`
+		R int64  `xml:"r,attr"`
+	} `xml:"SegmentTimeline>S"`
+}
+
+type mpdSegmentList struct {
+	Segments []struct {
+		Media string `xml:"media,attr"`
+	} `xml:"SegmentURL"`
+}
+
+type mpdRepresentation struct {
+	ID              string              `xml:"id,attr"`
+	Bandwidth       int64               `xml:"bandwidth,attr"`
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+}
+
+type mpdManifest struct {
+	BaseURL  string `xml:"BaseURL"`
+	Duration string `xml:"mediaPresentationDuration,attr"`
+	Periods  []struct {
+		BaseURL        string `xml:"BaseURL"`
+		Duration       string `xml:"duration,attr"`
+		AdaptationSets []struct {
+			MimeType        string              `xml:"mimeType,attr"`
+			ContentType     string              `xml:"contentType,attr"`
+			BaseURL         string              `xml:"BaseURL"`
+			SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+			SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+			Representations []mpdRepresentation `xml:"Representation"`
+		} `xml:"AdaptationSet"`
+	} `xml:"Period"`
+}
+
+func isMPDResponse(url string, header http.Header) bool {
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	if strings.HasPrefix(contentType, "application/dash+xml") {
+		return true
+	}
+	parsed, err := neturl.Parse(url)
+	return err == nil && strings.HasSuffix(strings.ToLower(parsed.Path), ".mpd")
+}
+
+// Read chunks of a DASH stream described by the MPD manifest at `url`.
+// Chunks with positive offsets are read from the first media segment, and ones with negative offsets from the last.
+// Reported file size is the sum of both segment sizes, so the hash identifies the stream, but isn't a hash of any
+// single file. Streams with a single segment are hashed as that file.
+func readMPDChunks(ctx context.Context, client *http.Client, url string, opts Options, minimumRequiredSize int64, chunks ...chunkInfo) (fileSize int64, buf []byte, header http.Header, err error) {
+	first, last, err := resolveMPDSegments(ctx, client, url)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	// Segments are read as plain files, even when their names end in `.mpd`.
+	opts.inMPD = true
+
+	if first == last {
+		return readRemoteChunks(first, opts, minimumRequiredSize, chunks...)
+	}
+
+	var headChunks, tailChunks []chunkInfo
+	headMinimum, tailMinimum := int64(0), int64(0)
+	for _, chunk := range chunks {
+		if chunk.offset < 0 {
+			tailChunks = append(tailChunks, chunk)
+			tailMinimum = max(tailMinimum, -chunk.offset)
+		} else {
+			headChunks = append(headChunks, chunk)
+			headMinimum = max(headMinimum, chunk.offset+chunk.size)
+		}
+	}
+
+	// Both segments would otherwise be read into the start of the caller's buffer
+	segmentOpts := opts
+	segmentOpts.buffer = nil
+
+	headSize, headBuf, header, err := readRemoteChunks(first, segmentOpts, headMinimum, headChunks...)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	tailSize, tailBuf, _, err := readRemoteChunks(last, segmentOpts, tailMinimum, tailChunks...)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	fileSize = headSize + tailSize
+	if fileSize < minimumRequiredSize {
+		return 0, nil, nil, errors.New("file is too small to generate a valid hash")
+	}
+
+	// Put chunks back in their requested order
+	buf = opts.buffer[:0]
+	if cap(buf) < len(headBuf)+len(tailBuf) {
+		buf = make([]byte, 0, len(headBuf)+len(tailBuf))
+	}
+	for _, chunk := range chunks {
+		if chunk.offset < 0 {
+			buf, tailBuf = append(buf, tailBuf[:chunk.size]...), tailBuf[chunk.size:]
+		} else {
+			buf, headBuf = append(buf, headBuf[:chunk.size]...), headBuf[chunk.size:]
+		}
+	}
+	return fileSize, buf, header, nil
+}
+
+// Resolve URLs of the first and last media segments of the first video representation in the manifest.
+func resolveMPDSegments(ctx context.Context, client *http.Client, url string) (first, last string, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("couldn't fetch MPD manifest: %s", resp.Status)
+	}
+
+	var manifest mpdManifest
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 10<<20)).Decode(&manifest); err != nil {
+		return "", "", fmt.Errorf("couldn't parse MPD manifest: %w", err)
+	}
+	if len(manifest.Periods) == 0 {
+		return "", "", errors.New("MPD manifest has no periods")
+	}
+	period := manifest.Periods[0]
+
+	base, err := neturl.Parse(url)
+	if err != nil {
+		return "", "", err
+	}
+	base, err = resolveMPDBaseURL(base, manifest.BaseURL, period.BaseURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Prefer video, but fall back to whatever is first.
+	adaptationIndex := -1
+	for i, set := range period.AdaptationSets {
+		if len(set.Representations) > 0 && (strings.HasPrefix(set.MimeType, "video/") || set.ContentType == "video") {
+			adaptationIndex = i
+			break
+		}
+		if adaptationIndex < 0 && len(set.Representations) > 0 {
+			adaptationIndex = i
+		}
+	}
+	if adaptationIndex < 0 {
+		return "", "", errors.New("MPD manifest has no representations")
+	}
+	set := period.AdaptationSets[adaptationIndex]
+	representation := set.Representations[0]
+
+	base, err = resolveMPDBaseURL(base, set.BaseURL, representation.BaseURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	template := representation.SegmentTemplate
+	if template == nil {
+		template = set.SegmentTemplate
+	}
+	list := representation.SegmentList
+	if list == nil {
+		list = set.SegmentList
+	}
+
+	var firstRef, lastRef string
+	switch {
+	case template != nil && template.Media != "":
+		periodDuration := period.Duration
+		if periodDuration == "" {
+			periodDuration = manifest.Duration
+		}
+		firstRef, lastRef, err = resolveMPDTemplate(template, representation, periodDuration)
+		if err != nil {
+			return "", "", err
+		}
+	case list != nil && len(list.Segments) > 0:
+		firstRef, lastRef = list.Segments[0].Media, list.Segments[len(list.Segments)-1].Media
+	default:
+		// Single file representation, addressed by base URL alone, which without any `BaseURL` is the manifest.
+		if sameURL(base.String(), url) {
+			return "", "", errors.New("MPD representation has no BaseURL or segments")
+		}
+		return base.String(), base.String(), nil
+	}
+
+	firstURL, err := base.Parse(firstRef)
+	if err != nil {
+		return "", "", err
+	}
+	lastURL, err := base.Parse(lastRef)
+	if err != nil {
+		return "", "", err
+	}
+	if sameURL(firstURL.String(), url) || sameURL(lastURL.String(), url) {
+		return "", "", errors.New("MPD segment URL is the manifest itself")
+	}
+	return firstURL.String(), lastURL.String(), nil
+}
+
+func sameURL(a, b string) bool {
+	if a == b {
+		return true
+	}
+	a, errA := CanonicaliseURL(a)
+	b, errB := CanonicaliseURL(b)
+	return errA == nil && errB == nil && a == b
+}
+
+func resolveMPDBaseURL(base *neturl.URL, refs ...string) (*neturl.URL, error) {
+	for _, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			continue
+		}
+		resolved, err := base.Parse(ref)
+		if err != nil {
+			return nil, err
+		}
+		base = resolved
+	}
+	return base, nil
+}
+
+var mpdTemplateIdentifierRE = regexp.MustCompile(`\$(RepresentationID|Number|Bandwidth|Time)(%0(\d+)d)?\$`)
+
+func resolveMPDTemplate(template *mpdSegmentTemplate, representation mpdRepresentation, periodDuration string) (first, last string, err error) {
+	startNumber := int64(1)
+	if template.StartNumber != nil {
+		startNumber = *template.StartNumber
+	}
+	timescale := int64(1)
+	if template.Timescale != nil && *template.Timescale > 0 {
+		timescale = *template.Timescale
+	}
+
+	firstTime, lastTime := template.PresentationTO, template.PresentationTO
+	segmentCount := int64(0)
+	if len(template.Timeline) > 0 {
+		time := int64(0)
+		for i, s := range template.Timeline {
+			if s.T != nil {
+				time = *s.T
+			}
+			if i == 0 {
+				firstTime = time
+			}
+			if s.D <= 0 {
+				return "", "", errors.New("MPD segment timeline has a segment without duration")
+			}
+			count := s.R + 1
+			if s.R < 0 {
+				// Repeats until the next segment's time, or the end of the period.
+				end := int64(0)
+				if i+1 < len(template.Timeline) && template.Timeline[i+1].T != nil {
+					end = *template.Timeline[i+1].T
+				} else {
+					duration, err := parseISO8601Duration(periodDuration)
+					if err != nil {
+						return "", "", fmt.Errorf("MPD segment timeline repeats to the end of a period without duration: %w", err)
+					}
+					end = template.PresentationTO + int64(duration.Seconds()*float64(timescale))
+				}
+				count = (end - time + s.D - 1) / s.D
+				if count < 1 {
+					return "", "", errors.New("MPD segment timeline repeats past its end")
+				}
+			}
+			lastTime = time + (count-1)*s.D
+			time += count * s.D
+			segmentCount += count
+		}
+	} else {
+		if template.Duration <= 0 {
+			return "", "", errors.New("MPD segment template has neither duration nor timeline")
+		}
+		duration, err := parseISO8601Duration(periodDuration)
+		if err != nil {
+			return "", "", err
+		}
+		segmentDuration := float64(template.Duration) / float64(timescale)
+		segmentCount = int64(math.Ceil(duration.Seconds() / segmentDuration))
+		lastTime = firstTime + (segmentCount-1)*template.Duration
+	}
+	if segmentCount < 1 {
+		return "", "", errors.New("MPD representation has no segments")
+	}
+
+	expand := func(number, time int64) string {
+		media := mpdTemplateIdentifierRE.ReplaceAllStringFunc(template.Media, func(match string) string {
+			parts := mpdTemplateIdentifierRE.FindStringSubmatch(match)
+			value := ""
+			switch parts[1] {
+			case "RepresentationID":
+				return representation.ID
+			case "Number":
+				value = strconv.FormatInt(number, 10)
+			case "Bandwidth":
+				value = strconv.FormatInt(representation.Bandwidth, 10)
+			case "Time":
+				value = strconv.FormatInt(time, 10)
+			}
+			if width, _ := strconv.Atoi(parts[3]); len(value) < width {
+				value = strings.Repeat("0", width-len(value)) + value
+			}
+			return value
+		})
+		return strings.ReplaceAll(media, "$$", "$")
+	}
+
+	return expand(startNumber, firstTime), expand(startNumber+segmentCount-1, lastTime), nil
+}
+
+var iso8601DurationRE = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// Parse durations such as `PT1H2M3.5S`, years and months aren't supported as they have no fixed length.
+func parseISO8601Duration(value string) (time.Duration, error) {
+	parts := iso8601DurationRE.FindStringSubmatch(strings.TrimSpace(value))
+	if parts == nil || value == "P" || value == "PT" {
+		return 0, fmt.Errorf("invalid duration \"%s\"", value)
+	}
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	total := time.Duration(0)
+	for i, unit := range units {
+		if parts[i+1] == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(parts[i+1], 64)
+		if err != nil {
+			return 0, err
+		}
+		total += time.Duration(n * float64(unit))
+	}
+	return total, nil
+}
@@ -0,0 +1,303 @@
+package lib
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"uosc/bins/src/ziggy/lib/testutil"
+)
+
+// Serve `files` by URL path, with `.mpd` files as `application/dash+xml`, and count the requests made.
+func startMPDServer(tb testing.TB, files map[string][]byte) (baseURL string, requests *atomic.Int64) {
+	tb.Helper()
+	requests = &atomic.Int64{}
+	modTime := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, ".mpd") {
+			w.Header().Set("Content-Type", "application/dash+xml")
+		}
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+	}))
+	tb.Cleanup(server.Close)
+	return server.URL, requests
+}
+
+func TestOSDBHashMPD(t *testing.T) {
+	first := readSyntheticFile(t, 200000)
+	last := readSyntheticFile(t, 300000)
+	single := readSyntheticFile(t, 400000)
+
+	head := first[:OSDBChunkSize]
+	tail := last[len(last)-OSDBChunkSize:]
+	segmentsHash := osdbHash(int64(len(first)+len(last)), append(append([]byte{}, head...), tail...))
+
+	tests := []struct {
+		name     string
+		manifest string
+		expected string
+	}{
+		{
+			name: "segment list",
+			manifest: `<MPD><Period><AdaptationSet mimeType="video/mp4"><Representation id="v">
+				<SegmentList><SegmentURL media="seg-1.m4s"/><SegmentURL media="seg-2.m4s"/></SegmentList>
+			</Representation></AdaptationSet></Period></MPD>`,
+			expected: segmentsHash,
+		},
+		{
+			name: "segment template",
+			manifest: `<MPD mediaPresentationDuration="PT8S"><Period><AdaptationSet mimeType="video/mp4">
+				<SegmentTemplate media="seg-$Number$.m4s" duration="4" />
+				<Representation id="v"/>
+			</AdaptationSet></Period></MPD>`,
+			expected: segmentsHash,
+		},
+		{
+			name: "video preferred over audio",
+			manifest: `<MPD><Period>
+				<AdaptationSet mimeType="audio/mp4"><Representation id="a"><BaseURL>missing.m4a</BaseURL></Representation></AdaptationSet>
+				<AdaptationSet contentType="video"><Representation id="v"><BaseURL>video.mp4</BaseURL></Representation></AdaptationSet>
+			</Period></MPD>`,
+			expected: testutil.KnownHash(int64(len(single))),
+		},
+		{
+			name: "nested base URLs",
+			manifest: `<MPD><BaseURL>media/</BaseURL><Period><AdaptationSet mimeType="video/mp4"><BaseURL>v/</BaseURL>
+				<Representation id="v"><BaseURL>video.mp4</BaseURL></Representation>
+			</AdaptationSet></Period></MPD>`,
+			expected: testutil.KnownHash(int64(len(single))),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseURL, _ := startMPDServer(t, map[string][]byte{
+				"/stream.mpd":        []byte(tt.manifest),
+				"/seg-1.m4s":         first,
+				"/seg-2.m4s":         last,
+				"/video.mp4":         single,
+				"/media/v/video.mp4": single,
+			})
+			hash, err := OSDBHashFile(baseURL + "/stream.mpd")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != tt.expected {
+				t.Errorf("hash is %s, expected %s", hash, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOSDBHashMPDSelfReference(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+	}{
+		{
+			name:     "no base URL or segments",
+			manifest: `<MPD><Period><AdaptationSet mimeType="video/mp4"><Representation id="v"/></AdaptationSet></Period></MPD>`,
+		},
+		{
+			name: "base URL of the manifest",
+			manifest: `<MPD><Period><AdaptationSet mimeType="video/mp4">
+				<Representation id="v"><BaseURL>stream.mpd</BaseURL></Representation>
+			</AdaptationSet></Period></MPD>`,
+		},
+		{
+			name: "segment of the manifest",
+			manifest: `<MPD><Period><AdaptationSet mimeType="video/mp4"><Representation id="v">
+				<SegmentList><SegmentURL media="seg-1.m4s"/><SegmentURL media="/stream.mpd"/></SegmentList>
+			</Representation></AdaptationSet></Period></MPD>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseURL, requests := startMPDServer(t, map[string][]byte{
+				"/stream.mpd": []byte(tt.manifest),
+				"/seg-1.m4s":  readSyntheticFile(t, 200000),
+			})
+			if hash, err := OSDBHashFile(baseURL + "/stream.mpd"); err == nil {
+				t.Fatalf("hashing succeeded with %s", hash)
+			}
+			// The manifest's HEAD and GET, and nothing else.
+			if n := requests.Load(); n > 2 {
+				t.Errorf("made %d requests, expected 2", n)
+			}
+		})
+	}
+}
+
+func TestResolveMPDTemplate(t *testing.T) {
+	n := func(v int64) *int64 { return &v }
+	type segment = struct {
+		T *int64 `xml:"t,attr"`
+		D int64  `xml:"d,attr"`
+		R int64  `xml:"r,attr"`
+	}
+	representation := mpdRepresentation{ID: "video=1", Bandwidth: 500000}
+
+	tests := []struct {
+		name           string
+		template       mpdSegmentTemplate
+		periodDuration string
+		first, last    string
+		wantErr        bool
+	}{
+		{
+			name:           "duration",
+			template:       mpdSegmentTemplate{Media: "$Number$.m4s", Duration: 4},
+			periodDuration: "PT10S",
+			first:          "1.m4s",
+			last:           "3.m4s",
+		},
+		{
+			name:           "duration with timescale and start number",
+			template:       mpdSegmentTemplate{Media: "$Number%05d$.m4s", Duration: 2000, Timescale: n(1000), StartNumber: n(0)},
+			periodDuration: "PT1M",
+			first:          "00000.m4s",
+			last:           "00029.m4s",
+		},
+		{
+			name:           "identifiers",
+			template:       mpdSegmentTemplate{Media: "$RepresentationID$/$Bandwidth$/$$$Number$.m4s", Duration: 60},
+			periodDuration: "PT1H",
+			first:          "video=1/500000/$1.m4s",
+			last:           "video=1/500000/$60.m4s",
+		},
+		{
+			name: "timeline",
+			template: mpdSegmentTemplate{Media: "$Time$.m4s", Timeline: []segment{
+				{T: n(100), D: 10, R: 2},
+				{D: 5},
+			}},
+			first: "100.m4s",
+			last:  "130.m4s",
+		},
+		{
+			name: "timeline with gap",
+			template: mpdSegmentTemplate{Media: "$Number$-$Time$.m4s", Timeline: []segment{
+				{T: n(0), D: 10},
+				{T: n(50), D: 10, R: 1},
+			}},
+			first: "1-0.m4s",
+			last:  "3-60.m4s",
+		},
+		{
+			name: "timeline repeating to the next segment",
+			template: mpdSegmentTemplate{Media: "$Number$-$Time$.m4s", Timeline: []segment{
+				{T: n(0), D: 10, R: -1},
+				{T: n(95), D: 5},
+			}},
+			first: "1-0.m4s",
+			last:  "11-95.m4s",
+		},
+		{
+			name: "timeline repeating to the period end",
+			template: mpdSegmentTemplate{Media: "$Number$-$Time$.m4s", Timescale: n(10), PresentationTO: 1000, Timeline: []segment{
+				{T: n(1000), D: 20, R: -1},
+			}},
+			periodDuration: "PT1M",
+			first:          "1-1000.m4s",
+			last:           "30-1580.m4s",
+		},
+		{
+			name: "timeline repeating to a period end without duration",
+			template: mpdSegmentTemplate{Media: "$Time$.m4s", Timeline: []segment{
+				{T: n(0), D: 10, R: -1},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "timeline repeating past its end",
+			template: mpdSegmentTemplate{Media: "$Time$.m4s", Timeline: []segment{
+				{T: n(100), D: 10, R: -1},
+				{T: n(50), D: 10},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "timeline segment without duration",
+			template: mpdSegmentTemplate{Media: "$Time$.m4s", Timeline: []segment{
+				{T: n(0), D: 0, R: -1},
+			}},
+			periodDuration: "PT1M",
+			wantErr:        true,
+		},
+		{
+			name:           "neither duration nor timeline",
+			template:       mpdSegmentTemplate{Media: "$Number$.m4s"},
+			periodDuration: "PT1M",
+			wantErr:        true,
+		},
+		{
+			name:     "duration without period duration",
+			template: mpdSegmentTemplate{Media: "$Number$.m4s", Duration: 4},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, last, err := resolveMPDTemplate(&tt.template, representation, tt.periodDuration)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("succeeded with %s and %s", first, last)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first != tt.first || last != tt.last {
+				t.Errorf("got %s and %s, expected %s and %s", first, last, tt.first, tt.last)
+			}
+		})
+	}
+}
+
+func TestParseISO8601Duration(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{value: "PT30S", expected: 30 * time.Second},
+		{value: "PT1H2M3.5S", expected: time.Hour + 2*time.Minute + 3500*time.Millisecond},
+		{value: "PT90M", expected: 90 * time.Minute},
+		{value: "P1D", expected: 24 * time.Hour},
+		{value: "P1DT12H", expected: 36 * time.Hour},
+		{value: " PT1S ", expected: time.Second},
+		{value: "P", wantErr: true},
+		{value: "PT", wantErr: true},
+		{value: "P1Y", wantErr: true},
+		{value: "P1M", wantErr: true},
+		{value: "1H", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			duration, err := parseISO8601Duration(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("succeeded with %v", duration)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if duration != tt.expected {
+				t.Errorf("got %v, expected %v", duration, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"strings"
+)
+
+var ErrDataURITooSmall = errors.New("data URI payload is too small to generate a valid hash")
+
+// Generate an OSDB hash for data that's already in memory.
+func OSDBHashBytes(data []byte) (string, error) {
+	spans := []chunkInfo{
+		{0, OSDBChunkSize},
+		{-OSDBChunkSize, OSDBChunkSize},
+	}
+	fileSize, buf, err := readReaderChunks(bytesChunkReader(data), "", newOptions(nil), OSDBChunkSize, spans...)
+	if err != nil {
+		return "", err
+	}
+	return osdbHash(fileSize, buf), nil
+}
+
+// Decode the payload of a `data:[<mediatype>][;base64],<data>` URI.
+func decodeDataURI(uri string) ([]byte, error) {
+	header, payload, found := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !found {
+		return nil, errors.New("data URI is missing its payload")
+	}
+	if strings.HasSuffix(strings.ToLower(header), ";base64") {
+		// Some encoders leave out padding
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid data URI payload: %w", err)
+		}
+		return data, nil
+	}
+	data, err := neturl.PathUnescape(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data URI payload: %w", err)
+	}
+	return []byte(data), nil
+}
+
+// ChunkReader of an in-memory file.
+type bytesChunkReader []byte
+
+func (r bytesChunkReader) Size() (int64, error) {
+	return int64(len(r)), nil
+}
+
+func (r bytesChunkReader) ReadChunk(offset int64, buf []byte) error {
+	if offset < 0 || offset+int64(len(buf)) > int64(len(r)) {
+		return fmt.Errorf("invalid read at %v", offset)
+	}
+	copy(buf, r[offset:])
+	return nil
+}
@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Generate a lowercase hex SHA3-256 digest of the whole file.
+func SHA3HashFile(filePath string) (string, error) {
+	return digestFile(filePath, sha3.New256())
+}
+
+// Generate a lowercase hex SHA-256 digest of the whole file, as printed by `sha256sum`.
+func SHA256HashFile(filePath string) (string, error) {
+	return digestFile(filePath, sha256.New())
+}
+
+// Generate a lowercase hex MD5 digest of the whole file.
+func MD5HashFile(filePath string) (string, error) {
+	return digestFile(filePath, md5.New())
+}
+
+// Generate a lowercase hex SHA-1 digest of the first `prefixBytes` bytes of a file or URL, as used by legacy
+// subtitle APIs with 64k (`OSDBChunkSize`) prefixes. Files shorter than `prefixBytes` can't be hashed.
+func SHA1PrefixHash(filePath string, prefixBytes int64) (string, error) {
+	_, prefix, err := readChunks(filePath, newOptions(nil), prefixBytes, chunkInfo{0, prefixBytes})
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(prefix)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type HashAlgorithm string
+
+const (
+	AlgoOSDB HashAlgorithm = "osdb"
+	AlgoMD5  HashAlgorithm = "md5"
+	// SHA-1 of a file's first bytes, see `SHA1PrefixHash()`.
+	AlgoSHA1Prefix HashAlgorithm = "sha1-prefix"
+	AlgoSHA3_256   HashAlgorithm = "sha3-256"
+	AlgoSHA256     HashAlgorithm = "sha256"
+	AlgoSHA1       HashAlgorithm = "sha1"
+)
+
+// Hash a file with `algorithm`, such as one returned by `AutoHashFile()` or read from a manifest.
+// `AlgoSHA1Prefix` hashes the first `OSDBChunkSize` bytes.
+func HashFileWith(algorithm HashAlgorithm, filePath string) (string, error) {
+	switch algorithm {
+	case AlgoOSDB:
+		return OSDBHashFile(filePath)
+	case AlgoMD5:
+		return MD5HashFile(filePath)
+	case AlgoSHA1Prefix:
+		return SHA1PrefixHash(filePath, OSDBChunkSize)
+	case AlgoSHA3_256:
+		return SHA3HashFile(filePath)
+	case AlgoSHA256:
+		return SHA256HashFile(filePath)
+	case AlgoSHA1:
+		return digestFile(filePath, sha1.New())
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %q", algorithm)
+	}
+}
+
+// Hashes of a file from `HashFileMulti()`, as lowercase hex.
+type MultiHashResult struct {
+	OSDB string
+	// Digests of the whole file
+	MD5, SHA256, SHA1 string
+}
+
+// Hashes keyed by their `HashAlgorithm`, such as `{"osdb":"…","md5":"…"}`.
+func (r MultiHashResult) AsMap() map[string]string {
+	return map[string]string{
+		string(AlgoOSDB):   r.OSDB,
+		string(AlgoMD5):    r.MD5,
+		string(AlgoSHA256): r.SHA256,
+		string(AlgoSHA1):   r.SHA1,
+	}
+}
+
+// Serialized as `AsMap()`, so keys are the same as in manifests and `HashFileWith()`.
+func (r MultiHashResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.AsMap())
+}
+
+// Generate the OSDB hash of a local file along with its MD5, SHA-256, and SHA-1 digests, which are all computed in
+// a single pass over the file. Files too small for an OSDB hash fail.
+func HashFileMulti(filePath string) (MultiHashResult, error) {
+	osdb, err := OSDBHashFile(filePath)
+	if err != nil {
+		return MultiHashResult{}, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return MultiHashResult{}, errors.New("couldn't open file for hashing")
+	}
+	defer file.Close()
+
+	md5Hash, sha256Hash, sha1Hash := md5.New(), sha256.New(), sha1.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha256Hash, sha1Hash), file); err != nil {
+		return MultiHashResult{}, err
+	}
+	return MultiHashResult{
+		OSDB:   osdb,
+		MD5:    hex.EncodeToString(md5Hash.Sum(nil)),
+		SHA256: hex.EncodeToString(sha256Hash.Sum(nil)),
+		SHA1:   hex.EncodeToString(sha1Hash.Sum(nil)),
+	}, nil
+}
+
+// Files smaller than this are unlikely to be movies or episodes, so `AutoHashFile()` doesn't OSDB hash them.
+const AutoHashOSDBMinSize = 5 * 1024 * 1024 // 5MB
+
+// Hash a file with the algorithm suited to its size: OSDB for files of at least `AutoHashOSDBMinSize`, which are
+// likely videos worth looking up subtitles for, and an MD5 of the whole file for smaller ones, which aren't worth
+// submitting to OSDB. Check `algorithm` before sending `hash` anywhere.
+// URLs are always OSDB hashed, as anything else needs the whole file, but not when their `Content-Type` is a known
+// non-video one, such as subtitles at `https://cdn.example.com/abc123`, which fail with `ErrNotVideoFile`.
+func AutoHashFile(filePath string) (algorithm HashAlgorithm, hash string, err error) {
+	if strings.HasPrefix(filePath, "http://") || strings.HasPrefix(filePath, "https://") {
+		ext, err := InferExtensionFromContentType(filePath)
+		if err != nil && !errors.Is(err, ErrUnknownContentType) {
+			return "", "", err
+		}
+		if spec, ok := FileTypes.Lookup(ext); err == nil && ext != ".mpd" && !(ok && spec.IsVideo()) {
+			return "", "", fmt.Errorf("%w, it's served as %s", ErrNotVideoFile, ext)
+		}
+		hash, err = OSDBHashFile(filePath)
+		return AlgoOSDB, hash, err
+	}
+
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return "", "", errors.New("couldn't stat file for hashing")
+	}
+	if fi.Size() < AutoHashOSDBMinSize {
+		hash, err = MD5HashFile(filePath)
+		return AlgoMD5, hash, err
+	}
+	hash, err = OSDBHashFile(filePath)
+	return AlgoOSDB, hash, err
+}
+
+// Stream the whole file through `h`, so big files don't need to be loaded into memory.
+func digestFile(filePath string, h hash.Hash) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", errors.New("couldn't open file for hashing")
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
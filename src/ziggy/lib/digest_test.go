@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"uosc/bins/src/ziggy/lib/testutil"
+)
+
+func TestHashFileMulti(t *testing.T) {
+	const size = 1 << 20
+	path := testutil.CreateSyntheticVideoFile(t, size)
+	data := readSyntheticFile(t, size)
+	md5Sum, sha256Sum, sha1Sum := md5.Sum(data), sha256.Sum256(data), sha1.Sum(data)
+	expected := MultiHashResult{
+		OSDB:   testutil.KnownHash(size),
+		MD5:    hex.EncodeToString(md5Sum[:]),
+		SHA256: hex.EncodeToString(sha256Sum[:]),
+		SHA1:   hex.EncodeToString(sha1Sum[:]),
+	}
+
+	result, err := HashFileMulti(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != expected {
+		t.Errorf("hashes are %+v, expected %+v", result, expected)
+	}
+
+	var decoded map[string]string
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for algorithm, hash := range result.AsMap() {
+		if decoded[algorithm] != hash {
+			t.Errorf("%s is %q in JSON, expected %q", algorithm, decoded[algorithm], hash)
+		}
+		if fromFile, err := HashFileWith(HashAlgorithm(algorithm), path); err != nil || fromFile != hash {
+			t.Errorf("HashFileWith(%s) is %s, expected %s", algorithm, fromFile, hash)
+		}
+	}
+
+	if _, err := HashFileMulti(testutil.CreateSyntheticVideoFile(t, OSDBChunkSize-1)); err == nil {
+		t.Error("hashing a file smaller than a chunk succeeded")
+	}
+}
+
+func TestHashFileWith(t *testing.T) {
+	abc := filepath.Join(t.TempDir(), "abc.txt")
+	if err := os.WriteFile(abc, []byte("abc"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	const size = 1 << 20
+	video := testutil.CreateSyntheticVideoFile(t, size)
+	prefix := sha1.Sum(readSyntheticFile(t, size)[:OSDBChunkSize])
+
+	tests := []struct {
+		algorithm HashAlgorithm
+		path      string
+		expected  string
+		wantErr   bool
+	}{
+		{algorithm: AlgoMD5, path: abc, expected: "900150983cd24fb0d6963f7d28e17f72"},
+		{algorithm: AlgoSHA3_256, path: abc, expected: "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"},
+		{algorithm: AlgoOSDB, path: video, expected: testutil.KnownHash(size)},
+		{algorithm: AlgoSHA1Prefix, path: video, expected: hex.EncodeToString(prefix[:])},
+		{algorithm: AlgoOSDB, path: abc, wantErr: true},
+		{algorithm: AlgoSHA1Prefix, path: abc, wantErr: true},
+		{algorithm: "sha512", path: abc, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.algorithm)+" "+filepath.Base(tt.path), func(t *testing.T) {
+			hash, err := HashFileWith(tt.algorithm, tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("succeeded with %s", hash)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != tt.expected {
+				t.Errorf("hash is %s, expected %s", hash, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAutoHashFile(t *testing.T) {
+	tests := []struct {
+		size      int64
+		algorithm HashAlgorithm
+	}{
+		{OSDBChunkSize, AlgoMD5},
+		{AutoHashOSDBMinSize - 1, AlgoMD5},
+		{AutoHashOSDBMinSize, AlgoOSDB},
+	}
+	for _, tt := range tests {
+		path := testutil.CreateSyntheticVideoFile(t, tt.size)
+		algorithm, hash, err := AutoHashFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if algorithm != tt.algorithm {
+			t.Errorf("%d bytes were hashed with %s, expected %s", tt.size, algorithm, tt.algorithm)
+		}
+		if expected, _ := HashFileWith(tt.algorithm, path); hash != expected {
+			t.Errorf("hash of %d bytes is %s, expected %s", tt.size, hash, expected)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"encoding/binary"
+)
+
+// ETagStore keeps chunks of remote files along with the ETag they were read at, see `WithETagCache()`.
+// `data` is opaque, and should be stored as is.
+type ETagStore interface {
+	Get(url string) (etag, data string, ok bool)
+	Set(url, etag string, data []byte)
+}
+
+// Chunks are cached as the file size, followed by offset and size of each chunk, followed by the chunk buffer.
+func encodeCachedChunks(fileSize int64, chunks []chunkInfo, buf []byte) []byte {
+	data := make([]byte, 0, 8+16*len(chunks)+len(buf))
+	data = binary.LittleEndian.AppendUint64(data, uint64(fileSize))
+	for _, chunk := range chunks {
+		data = binary.LittleEndian.AppendUint64(data, uint64(chunk.offset))
+		data = binary.LittleEndian.AppendUint64(data, uint64(chunk.size))
+	}
+	return append(data, buf...)
+}
+
+// Look up chunks of `url` in `store`, only returning them when they were cached for the same `chunks`.
+func lookupCachedChunks(store ETagStore, url string, chunks []chunkInfo) (etag string, fileSize int64, buf []byte, ok bool) {
+	if store == nil {
+		return "", 0, nil, false
+	}
+	etag, data, ok := store.Get(url)
+	if !ok || etag == "" || len(data) < 8+16*len(chunks) {
+		return "", 0, nil, false
+	}
+
+	fileSize = int64(binary.LittleEndian.Uint64([]byte(data[:8])))
+	data = data[8:]
+	total := int64(0)
+	for _, chunk := range chunks {
+		offset := int64(binary.LittleEndian.Uint64([]byte(data[:8])))
+		size := int64(binary.LittleEndian.Uint64([]byte(data[8:16])))
+		if offset != chunk.offset || size != chunk.size {
+			return "", 0, nil, false
+		}
+		total += size
+		data = data[16:]
+	}
+	if int64(len(data)) != total {
+		return "", 0, nil, false
+	}
+	return etag, fileSize, []byte(data), true
+}
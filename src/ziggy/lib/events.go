@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// Published every time a watched file is hashed.
+	EventHashComputed = "hash.computed"
+	// Published when a watched file's hash differs from the one it had before.
+	EventHashChanged = "hash.changed"
+)
+
+type HashEvent struct {
+	Path         string `json:"path"`
+	Hash         string `json:"hash"`
+	PreviousHash string `json:"previous_hash,omitempty"`
+	FileSize     int64  `json:"file_size"`
+	Err          error  `json:"-"`
+}
+
+// EventBus delivers hash events to handlers subscribed to them, in the order they subscribed.
+type EventBus struct {
+	mutex    sync.RWMutex
+	handlers map[string][]func(HashEvent)
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{handlers: map[string][]func(HashEvent){}}
+}
+
+func (b *EventBus) Subscribe(event string, handler func(HashEvent)) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.handlers[event] = append(b.handlers[event], handler)
+}
+
+// Call handlers of `event` with `data`, returning once all of them did.
+func (b *EventBus) Publish(event string, data HashEvent) {
+	b.mutex.RLock()
+	handlers := b.handlers[event]
+	b.mutex.RUnlock()
+	for _, handler := range handlers {
+		handler(data)
+	}
+}
+
+// Call `handler` whenever a watched file's hash changes.
+func SubscribeHashChanges(bus *EventBus, handler func(HashEvent)) {
+	bus.Subscribe(EventHashChanged, handler)
+}
+
+// Poll a file every `interval`, hashing it whenever its size or modification time change, until `ctx` is done.
+// Every hash is published to `bus` as `EventHashComputed`, and ones that differ from the previous hash
+// as `EventHashChanged` too. Files that can't be hashed are published with `Err` set.
+func WatchFile(ctx context.Context, bus *EventBus, filePath string, interval time.Duration, opts ...Option) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastSize int64 = -1
+	var lastModTime time.Time
+	previousHash := ""
+	for {
+		fi, err := os.Stat(filePath)
+		if err == nil && (fi.Size() != lastSize || !fi.ModTime().Equal(lastModTime)) {
+			lastSize, lastModTime = fi.Size(), fi.ModTime()
+
+			result, err := OSDBHashFileResult(filePath, opts...)
+			event := HashEvent{Path: filePath, Hash: result.Hash, PreviousHash: previousHash, FileSize: fi.Size(), Err: err}
+			bus.Publish(EventHashComputed, event)
+			if err == nil {
+				if previousHash != "" && result.Hash != previousHash {
+					bus.Publish(EventHashChanged, event)
+				}
+				previousHash = result.Hash
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
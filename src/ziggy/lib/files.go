@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Write `data` to `path` so that readers either see the old or the new contents, never a partial write.
+// Data is written and synced to `path+".tmp"` first, which is then renamed over `path`.
+func WriteAtomically(path string, data []byte, perm os.FileMode) (err error) {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+	}()
+
+	// Only a hint, the write reports running out of space anyway.
+	preallocate(file, int64(len(data)))
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// Reserve `size` bytes of disk space for `path`, creating it if needed, so a big file written to it later isn't
+// fragmented. File size isn't changed. Uses `fallocate(2)` on Linux, `F_PREALLOCATE` on macOS, and
+// `FileAllocationInfo` on Windows, and does nothing on file systems and platforms that don't support it.
+func PreallocateFile(path string, size int64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	if err := preallocate(file, size); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Insert `[hash]` before the extension of `originalPath`, so `Movie.Name.mkv` becomes `Movie.Name.[hash].mkv`.
+// Names that already have the hash there are returned as is.
+func HashFilename(originalPath, hash string) string {
+	ext := filepath.Ext(originalPath)
+	base := strings.TrimSuffix(originalPath, ext)
+	if strings.HasSuffix(base, ".["+hash+"]") {
+		return originalPath
+	}
+	return base + ".[" + hash + "]" + ext
+}
+
+// Hash a file and rename it to `HashFilename()`, refusing to replace an existing file with that name.
+// Gives up with `ctx`'s error if it's done before hashing finishes.
+func RenameWithHash(ctx context.Context, path string) (newPath string, err error) {
+	hash, err := OSDBHashFile(path, WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+
+	newPath = HashFilename(path, hash)
+	if newPath == path {
+		return path, nil
+	}
+	// Checking first and renaming after could replace a file created in between.
+	if err := renameNoReplace(path, newPath); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("can't rename, %s already exists", newPath)
+		}
+		return "", err
+	}
+	return newPath, nil
+}
+
+// Rename with a hard link, which fails when `newPath` exists, for platforms without a rename that does.
+func linkRename(oldPath, newPath string) error {
+	if err := os.Link(oldPath, newPath); err != nil {
+		return err
+	}
+	return os.Remove(oldPath)
+}
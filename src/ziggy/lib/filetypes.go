@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	pathpkg "path"
+	"strings"
+	"sync"
+)
+
+var ErrUnknownContentType = errors.New("content type doesn't map to a known file extension")
+
+// How files of a type are hashed.
+type FileTypeSpec struct {
+	Extension string `json:"extension"`
+	MIMEType  string `json:"mime_type"`
+	// Smallest file that can be hashed, never less than `ChunkSize`.
+	MinSize int64 `json:"min_size"`
+	// Size of the head and tail chunks.
+	ChunkSize int64 `json:"chunk_size"`
+}
+
+// FileTypeRegistry maps file extensions to how files with them are hashed.
+type FileTypeRegistry struct {
+	mutex sync.RWMutex
+	types map[string]FileTypeSpec
+}
+
+func NewFileTypeRegistry() *FileTypeRegistry {
+	return &FileTypeRegistry{types: map[string]FileTypeSpec{}}
+}
+
+// Extension and MIME type of common video containers.
+var videoFileTypes = [][2]string{
+	{".3gp", "video/3gpp"},
+	{".asf", "video/x-ms-asf"},
+	{".avi", "video/x-msvideo"},
+	{".flv", "video/x-flv"},
+	{".m2ts", "video/mp2t"},
+	{".m4v", "video/x-m4v"},
+	{".mkv", "video/x-matroska"},
+	{".mov", "video/quicktime"},
+	{".mp4", "video/mp4"},
+	{".mpeg", "video/mpeg"},
+	{".mpg", "video/mpeg"},
+	{".mts", "video/mp2t"},
+	{".ogv", "video/ogg"},
+	{".rm", "video/vnd.rn-realvideo"},
+	{".rmvb", "video/vnd.rn-realvideo"},
+	{".ts", "video/mp2t"},
+	{".vob", "video/mpeg"},
+	{".webm", "video/webm"},
+	{".wmv", "video/x-ms-wmv"},
+}
+
+// Registry `OSDBHashFile()` looks up file types in. Has common video types with the standard 64k OSDB chunks.
+var FileTypes = func() *FileTypeRegistry {
+	registry := NewFileTypeRegistry()
+	for _, fileType := range videoFileTypes {
+		registry.Register(fileType[0], fileType[1], OSDBChunkSize, OSDBChunkSize)
+	}
+	return registry
+}()
+
+// Hash files ending with `extension`, such as `.mp4` or `mp4`, with `chunkSize` chunks, refusing files smaller than
+// `minSize`. Replaces an earlier registration of the same extension.
+func (r *FileTypeRegistry) Register(extension, mimeType string, minSize int64, chunkSize int64) {
+	extension = normaliseExtension(extension)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.types[extension] = FileTypeSpec{
+		Extension: extension,
+		MIMEType:  mimeType,
+		MinSize:   max(minSize, chunkSize),
+		ChunkSize: chunkSize,
+	}
+}
+
+// Find the spec for the extension of `filePath`, which can also be a URL.
+func (r *FileTypeRegistry) Lookup(filePath string) (FileTypeSpec, bool) {
+	// Query and fragment aren't part of a URL's extension.
+	if strings.Contains(filePath, "://") {
+		filePath, _, _ = strings.Cut(filePath, "?")
+		filePath, _, _ = strings.Cut(filePath, "#")
+	}
+	extension := normaliseExtension(pathpkg.Ext(strings.ReplaceAll(filePath, `\`, "/")))
+	if extension == "." {
+		return FileTypeSpec{}, false
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	spec, ok := r.types[extension]
+	return spec, ok
+}
+
+// Whether the type's MIME type is a `video/` one.
+func (spec FileTypeSpec) IsVideo() bool {
+	return strings.HasPrefix(spec.MIMEType, "video/")
+}
+
+func normaliseExtension(extension string) string {
+	return "." + strings.ToLower(strings.TrimPrefix(extension, "."))
+}
+
+// Media types servers send for video and subtitle files, including common non-standard ones, with the extension
+// files of that type usually have.
+var contentTypeExtensions = map[string]string{
+	"video/3gpp":                    ".3gp",
+	"video/avi":                     ".avi",
+	"video/mp2t":                    ".ts",
+	"video/mp4":                     ".mp4",
+	"video/mpeg":                    ".mpg",
+	"video/msvideo":                 ".avi",
+	"video/ogg":                     ".ogv",
+	"video/quicktime":               ".mov",
+	"video/vnd.rn-realvideo":        ".rm",
+	"video/webm":                    ".webm",
+	"video/x-flv":                   ".flv",
+	"video/x-m4v":                   ".m4v",
+	"video/x-matroska":              ".mkv",
+	"video/x-ms-asf":                ".asf",
+	"video/x-ms-wmv":                ".wmv",
+	"video/x-msvideo":               ".avi",
+	"application/vnd.rn-realmedia":  ".rm",
+	"application/x-matroska":        ".mkv",
+	"application/x-subrip":          ".srt",
+	"text/vtt":                      ".vtt",
+	"text/x-ssa":                    ".ssa",
+	"text/x-ass":                    ".ass",
+	"application/vnd.apple.mpegurl": ".m3u8",
+	"application/dash+xml":          ".mpd",
+}
+
+// Guess the extension of a remote file from the `Content-Type` of a HEAD request to `url`, for URLs such as
+// `https://cdn.example.com/abc123` that don't end with one. Fails with `ErrUnknownContentType` when the type isn't
+// a known video, subtitle, or streaming manifest one.
+func InferExtensionFromContentType(url string, opts ...Option) (ext string, err error) {
+	options := newOptions(opts)
+	url, err = CanonicaliseURL(url)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(optionsContext(options), options.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return "", err
+	}
+	client, release := newHTTPClient(options)
+	defer release()
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("HEAD request failed: %s", res.Status)
+	}
+	return contentTypeExtension(res.Header)
+}
+
+func contentTypeExtension(header http.Header) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return "", ErrUnknownContentType
+	}
+	ext, ok := contentTypeExtensions[strings.ToLower(mediaType)]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownContentType, mediaType)
+	}
+	return ext, nil
+}
+
+// Whether a remote file's `Content-Type` is that of a video file type in `FileTypes`.
+func isVideoContentType(header http.Header) bool {
+	ext, err := contentTypeExtension(header)
+	if err != nil {
+		return false
+	}
+	spec, ok := FileTypes.Lookup(ext)
+	return ok && spec.IsVideo()
+}
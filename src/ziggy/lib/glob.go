@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Find video files matching `pattern`, in lexicographic order. Patterns are `filepath.Glob()` ones, and a trailing
+// `/**` also matches everything under the directories matched by the rest of the pattern, such as `Movies/*/**`.
+// Only files with an extension registered as a video type in `FileTypes` are returned.
+func GlobHashFiles(pattern string) ([]string, error) {
+	recursive := false
+	for _, suffix := range []string{"/**", string(filepath.Separator) + "**"} {
+		if strings.HasSuffix(pattern, suffix) {
+			pattern, recursive = strings.TrimSuffix(pattern, suffix), true
+			break
+		}
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	paths := []string{}
+	add := func(path string) {
+		if spec, ok := FileTypes.Lookup(path); !ok || !spec.IsVideo() || seen[path] {
+			return
+		}
+		// Following symlinks
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, match := range matches {
+		err := filepath.WalkDir(match, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() && !recursive {
+				return fs.SkipDir
+			}
+			if !entry.IsDir() {
+				add(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
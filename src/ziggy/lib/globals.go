@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Process wide settings, used by every hash unless its own options say otherwise. Zero fields keep the built-in
+// defaults.
+type GlobalOptions struct {
+	// Used for remote reads instead of a client built from the options, except for reads with any of
+	// `WithConnectTimeout()`, `WithReadTimeout()`, `WithMaxConnsPerHost()`, `WithDialer()`, or `WithSOCKS5Proxy()`.
+	HTTPClient *http.Client
+	// Defaults for `WithTimeout()`, `WithRangeFallbackSizeLimit()`, and `WithMaxConnsPerHost()`.
+	Timeout                time.Duration
+	RangeFallbackSizeLimit int64
+	MaxConnsPerHost        int
+}
+
+var (
+	globalOptionsMutex sync.RWMutex
+	globalOptions      GlobalOptions
+)
+
+// Return a copy of the current global options, to change and pass on to `SetGlobalOptions()`.
+func GetGlobalOptions() GlobalOptions {
+	globalOptionsMutex.RLock()
+	defer globalOptionsMutex.RUnlock()
+	return globalOptions
+}
+
+// Replace all global options. Safe to call while hashes are running, but those already started may use either the
+// old or new options, so it's best done once at startup.
+func SetGlobalOptions(opts GlobalOptions) {
+	globalOptionsMutex.Lock()
+	defer globalOptionsMutex.Unlock()
+	globalOptions = opts
+}
@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+// Start from the defaults `OSDBHashFile()` uses without options.
+func NewHashConfig() HashConfig {
+	return newOptions(nil)
+}
+
+// Use all of `config`, replacing earlier options. Zero `Timeout`, `RangeFallbackSizeLimit`, `MaxConnsPerHost`, and
+// `MaxRetries` are left at their defaults, so `HashConfig{}` can be used as a starting point. Set `MaxConnsPerHost` to
+// -1 for no connection limit, and `MaxRetries` to -1 for no retries.
+func WithConfig(config HashConfig) Option {
+	return func(o *HashConfig) {
+		if config.Timeout == 0 {
+			config.Timeout = o.Timeout
+		}
+		if config.RangeFallbackSizeLimit == 0 {
+			config.RangeFallbackSizeLimit = o.RangeFallbackSizeLimit
+		}
+		if config.MaxConnsPerHost == 0 {
+			config.MaxConnsPerHost = o.MaxConnsPerHost
+		}
+		if config.MaxRetries == 0 {
+			config.MaxRetries = o.MaxRetries
+		}
+		config.buffer = o.buffer
+		*o = config
+	}
+}
+
+func (c HashConfig) with(opt Option) HashConfig {
+	opt(&c)
+	return c
+}
+
+func (c HashConfig) WithTimeout(timeout time.Duration) HashConfig {
+	return c.with(WithTimeout(timeout))
+}
+
+func (c HashConfig) WithConnectTimeout(timeout time.Duration) HashConfig {
+	return c.with(WithConnectTimeout(timeout))
+}
+
+func (c HashConfig) WithReadTimeout(timeout time.Duration) HashConfig {
+	return c.with(WithReadTimeout(timeout))
+}
+
+func (c HashConfig) WithMaxConnsPerHost(n int) HashConfig {
+	return c.with(WithMaxConnsPerHost(n))
+}
+
+func (c HashConfig) WithMaxRetries(n int) HashConfig {
+	return c.with(WithMaxRetries(n))
+}
+
+func (c HashConfig) WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) HashConfig {
+	return c.with(WithDialer(dial))
+}
+
+func (c HashConfig) WithSOCKS5Proxy(addr, user, pass string) HashConfig {
+	return c.with(WithSOCKS5Proxy(addr, user, pass))
+}
+
+func (c HashConfig) WithRangeLog(w io.Writer) HashConfig {
+	return c.with(WithRangeLog(w))
+}
+
+func (c HashConfig) WithReadAhead() HashConfig {
+	return c.with(WithReadAhead())
+}
+
+func (c HashConfig) WithETagCache(store ETagStore) HashConfig {
+	return c.with(WithETagCache(store))
+}
+
+func (c HashConfig) WithSizeHint(size int64) HashConfig {
+	return c.with(WithSizeHint(size))
+}
+
+func (c HashConfig) WithRespectRobots(respect bool) HashConfig {
+	return c.with(WithRespectRobots(respect))
+}
+
+func (c HashConfig) WithRangeFallbackSizeLimit(limit int64) HashConfig {
+	return c.with(WithRangeFallbackSizeLimit(limit))
+}
+
+func (c HashConfig) WithAuditLog(path string) HashConfig {
+	return c.with(WithAuditLog(path))
+}
+
+func (c HashConfig) WithAuditUser(user string) HashConfig {
+	return c.with(WithAuditUser(user))
+}
+
+func (c HashConfig) WithDeterministicOrder() HashConfig {
+	return c.with(WithDeterministicOrder())
+}
+
+func (c HashConfig) WithFileLock(timeout time.Duration) HashConfig {
+	return c.with(WithFileLock(timeout))
+}
+
+func (c HashConfig) WithFailFast() HashConfig {
+	return c.with(WithFailFast())
+}
+
+func (c HashConfig) WithReadStats() HashConfig {
+	return c.with(WithReadStats())
+}
+
+func (c HashConfig) WithContext(ctx context.Context) HashConfig {
+	return c.with(WithContext(ctx))
+}
@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"uosc/bins/src/ziggy/lib/testutil"
+)
+
+func TestWithMaxRetries(t *testing.T) {
+	const size = 1 << 20
+	data := readSyntheticFile(t, size)
+
+	// Server cuts off the first `failures` chunk responses half way.
+	startFlakyServer := func(failures int64) (url string) {
+		var requests atomic.Int64
+		modTime := time.Now()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var start, end int
+			if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err == nil &&
+				requests.Add(1) <= failures {
+				w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(data[start : start+(end-start)/2])
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+			http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+		}))
+		t.Cleanup(server.Close)
+		return server.URL + "/movie.mkv"
+	}
+
+	tests := []struct {
+		name     string
+		failures int64
+		opts     []Option
+		wantErr  bool
+	}{
+		{name: "default", failures: 2},
+		{name: "too many for default", failures: 3, wantErr: true},
+		{name: "option", failures: 3, opts: []Option{WithMaxRetries(3)}},
+		{name: "no retries", failures: 1, opts: []Option{WithMaxRetries(0)}, wantErr: true},
+		{name: "config", failures: 3, opts: []Option{WithConfig(HashConfig{}.WithMaxRetries(3))}},
+		{name: "config default", failures: 2, opts: []Option{WithConfig(HashConfig{})}},
+		{name: "config without retries", failures: 1, opts: []Option{WithConfig(HashConfig{MaxRetries: -1})}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := startFlakyServer(tt.failures)
+			// Chunks one after another, so the failures all hit the head chunk.
+			hash, err := OSDBHashFile(url, append(tt.opts, WithDeterministicOrder())...)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("succeeded with %s", hash)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := testutil.KnownHash(size); hash != expected {
+				t.Errorf("hash is %s, expected %s", hash, expected)
+			}
+		})
+	}
+}
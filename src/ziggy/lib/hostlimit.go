@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"strings"
+	"sync"
+)
+
+type hostLimitKey struct {
+	host  string
+	limit int
+}
+
+// Slots for reads in progress from a host, and how many reads are using or waiting for them.
+type hostLimit struct {
+	slots chan struct{}
+	users int
+}
+
+var (
+	hostLimitsMutex sync.Mutex
+	// Only hosts being read from have an entry, so this doesn't grow with every host ever read from.
+	hostLimits = map[hostLimitKey]*hostLimit{}
+)
+
+// Wrap chunk reads of `url` so at most `MaxConnsPerHost` of them run at once for its host, across all concurrent
+// reads with the same limit. This covers readers of registered URL schemes as well as HTTP, whose transport only
+// limits connections, and not reads waiting for one. Local files aren't limited.
+func limitHostReads(opts Options, url string, read func(offset int64, buf []byte) error) func(offset int64, buf []byte) error {
+	host := urlHost(url)
+	if opts.MaxConnsPerHost <= 0 || host == "" {
+		return read
+	}
+	key := hostLimitKey{host, opts.MaxConnsPerHost}
+	ctx := optionsContext(opts)
+
+	return func(offset int64, buf []byte) error {
+		limit := acquireHostLimit(key)
+		defer releaseHostLimit(key, limit)
+
+		select {
+		case limit.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-limit.slots }()
+		return read(offset, buf)
+	}
+}
+
+func acquireHostLimit(key hostLimitKey) *hostLimit {
+	hostLimitsMutex.Lock()
+	defer hostLimitsMutex.Unlock()
+	limit, ok := hostLimits[key]
+	if !ok {
+		limit = &hostLimit{slots: make(chan struct{}, key.limit)}
+		hostLimits[key] = limit
+	}
+	limit.users++
+	return limit
+}
+
+func releaseHostLimit(key hostLimitKey, limit *hostLimit) {
+	hostLimitsMutex.Lock()
+	defer hostLimitsMutex.Unlock()
+	limit.users--
+	if limit.users == 0 {
+		delete(hostLimits, key)
+	}
+}
+
+// Lowercase `scheme://host` of a URL, or empty for paths of local files.
+func urlHost(url string) string {
+	// Not using `url.Parse()` as it'd treat windows drive letters as schemes.
+	scheme, rest, found := strings.Cut(url, "://")
+	if !found {
+		return ""
+	}
+	host := rest
+	if end := strings.IndexAny(host, "/?#"); end >= 0 {
+		host = host[:end]
+	}
+	// Credentials aren't part of the host
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		host = host[at+1:]
+	}
+	return strings.ToLower(scheme + "://" + host)
+}
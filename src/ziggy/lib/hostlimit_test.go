@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type concurrencyChunkReader struct {
+	data              []byte
+	running, mostSeen *atomic.Int64
+}
+
+func (r concurrencyChunkReader) Size() (int64, error) {
+	return int64(len(r.data)), nil
+}
+
+func (r concurrencyChunkReader) ReadChunk(offset int64, buf []byte) error {
+	running := r.running.Add(1)
+	defer r.running.Add(-1)
+	for seen := r.mostSeen.Load(); running > seen && !r.mostSeen.CompareAndSwap(seen, running); {
+		seen = r.mostSeen.Load()
+	}
+	time.Sleep(5 * time.Millisecond)
+	return bytesChunkReader(r.data).ReadChunk(offset, buf)
+}
+
+func TestMaxConnsPerHostLimitsReads(t *testing.T) {
+	data := readSyntheticFile(t, 200000)
+	var running, mostSeen atomic.Int64
+	RegisterURLScheme("test-limit", func(url string, opts Options) (ChunkReader, error) {
+		return concurrencyChunkReader{data, &running, &mostSeen}, nil
+	})
+
+	for _, limit := range []int{1, 3} {
+		mostSeen.Store(0)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := OSDBHashFile("test-limit://host/movie.mkv", WithMaxConnsPerHost(limit)); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		if seen := mostSeen.Load(); seen > int64(limit) {
+			t.Errorf("%d reads ran at once with a limit of %d", seen, limit)
+		}
+	}
+	if len(hostLimits) != 0 {
+		t.Errorf("%d host limits are left after reads are done", len(hostLimits))
+	}
+}
+
+func TestURLHost(t *testing.T) {
+	tests := []struct{ url, expected string }{
+		{"https://CDN.example.com/movie.mkv", "https://cdn.example.com"},
+		{"http://user:p@ss@host:8080/movie.mkv?x=1", "http://host:8080"},
+		{"webdav://nas?path=/movie.mkv", "webdav://nas"},
+		{"/media/movie.mkv", ""},
+		{`C:\media\movie.mkv`, ""},
+	}
+	for _, tt := range tests {
+		if host := urlHost(tt.url); host != tt.expected {
+			t.Errorf("host of %s is %q, expected %q", tt.url, host, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ipfsChunkReader struct {
+	url     string
+	client  *http.Client
+	timeout time.Duration
+	retries int
+}
+
+// Read a file on IPFS by its CID through an HTTP gateway such as `https://ipfs.io`, fetching only the requested
+// byte ranges of `{gateway}/ipfs/{cid}`. Ranges are requested with the `Range` header, which path gateways
+// resolve against the file's content, rather than with `?format=raw`, which would return the CID's root block.
+func NewIPFSChunkReader(gateway string, cid string) ChunkReader {
+	return &ipfsChunkReader{
+		url:     strings.TrimRight(gateway, "/") + "/ipfs/" + cid,
+		client:  &http.Client{},
+		timeout: newOptions(nil).Timeout,
+		retries: newOptions(nil).MaxRetries,
+	}
+}
+
+func (r *ipfsChunkReader) Size() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", r.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("IPFS gateway responded with %s", res.Status)
+	}
+
+	contentLength := res.Header.Get("Content-Length")
+	if contentLength == "" {
+		return 0, errors.New("IPFS gateway didn't report file size")
+	}
+	return strconv.ParseInt(contentLength, 10, 64)
+}
+
+func (r *ipfsChunkReader) ReadChunk(offset int64, buf []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	return readRemoteChunk(ctx, r.client, r.url, offset, buf, r.retries)
+}
@@ -0,0 +1,144 @@
+package lib
+
+import (
+	pathpkg "path"
+	"regexp"
+	"strings"
+)
+
+// ISO 639-1 code, ISO 639-2 bibliographic and terminology codes, and English name of common subtitle languages.
+var subtitleLanguages = [][4]string{
+	{"ar", "ara", "ara", "arabic"},
+	{"bg", "bul", "bul", "bulgarian"},
+	{"ca", "cat", "cat", "catalan"},
+	{"cs", "cze", "ces", "czech"},
+	{"da", "dan", "dan", "danish"},
+	{"de", "ger", "deu", "german"},
+	{"el", "gre", "ell", "greek"},
+	{"en", "eng", "eng", "english"},
+	{"es", "spa", "spa", "spanish"},
+	{"et", "est", "est", "estonian"},
+	{"fa", "per", "fas", "persian"},
+	{"fi", "fin", "fin", "finnish"},
+	{"fr", "fre", "fra", "french"},
+	{"he", "heb", "heb", "hebrew"},
+	{"hi", "hin", "hin", "hindi"},
+	{"hr", "hrv", "hrv", "croatian"},
+	{"hu", "hun", "hun", "hungarian"},
+	{"id", "ind", "ind", "indonesian"},
+	{"is", "ice", "isl", "icelandic"},
+	{"it", "ita", "ita", "italian"},
+	{"ja", "jpn", "jpn", "japanese"},
+	{"ko", "kor", "kor", "korean"},
+	{"lt", "lit", "lit", "lithuanian"},
+	{"lv", "lav", "lav", "latvian"},
+	{"ms", "may", "msa", "malay"},
+	{"nl", "dut", "nld", "dutch"},
+	{"no", "nor", "nor", "norwegian"},
+	{"pl", "pol", "pol", "polish"},
+	{"pt", "por", "por", "portuguese"},
+	{"ro", "rum", "ron", "romanian"},
+	{"ru", "rus", "rus", "russian"},
+	{"sk", "slo", "slk", "slovak"},
+	{"sl", "slv", "slv", "slovenian"},
+	{"sr", "srp", "srp", "serbian"},
+	{"sv", "swe", "swe", "swedish"},
+	{"th", "tha", "tha", "thai"},
+	{"tr", "tur", "tur", "turkish"},
+	{"uk", "ukr", "ukr", "ukrainian"},
+	{"vi", "vie", "vie", "vietnamese"},
+	{"zh", "chi", "zho", "chinese"},
+}
+
+// Subtitle flags that commonly follow the language, as in `Movie.en.forced.srt`.
+var subtitleFlags = map[string]bool{"forced": true, "sdh": true, "hi": true, "cc": true, "default": true}
+
+var languageTokenDelimiterRE = regexp.MustCompile(`[.\s_\[\]()]+`)
+
+// Guess the language of a subtitle file from its path, returning an ISO 639-1 code (or a BCP-47 tag like `pt-BR`
+// when the file name has one) and a 0-1 confidence. Returns an empty code and 0 when nothing looks like a language.
+//
+// Language suffixes such as `Movie.en.srt` are the most reliable, followed by language names and 3 letter codes
+// anywhere in the file name, and directory names such as `Subs/fre/`.
+func GuessLanguageFromPath(path string) (lang string, confidence float64) {
+	// Windows separators are handled on every platform, as paths can come from another machine.
+	dir, file := pathpkg.Split(strings.ReplaceAll(path, `\`, "/"))
+	name := strings.TrimSuffix(file, pathpkg.Ext(file))
+	tokens := languageTokenDelimiterRE.Split(name, -1)
+
+	// Suffix right before the extension, skipping flags. `hi` is only a flag when it's not the sole suffix.
+	for i := len(tokens) - 1; i > 0; i-- {
+		token := tokens[i]
+		if subtitleFlags[strings.ToLower(token)] && i > 1 {
+			continue
+		}
+		if tag, ok := normaliseLanguageTag(token); ok {
+			if len(token) == 3 {
+				return tag, 0.85
+			}
+			return tag, 0.9
+		}
+		break
+	}
+
+	// Language names and 3 letter codes anywhere in the name, the last one wins as it's the closest to the suffix.
+	best, bestConfidence := "", 0.0
+	for _, token := range tokens[min(1, len(tokens)-1):] {
+		lower := strings.ToLower(token)
+		for _, language := range subtitleLanguages {
+			switch {
+			case lower == language[3]:
+				best, bestConfidence = language[0], 0.7
+			case len(lower) == 3 && (lower == language[1] || lower == language[2]) && bestConfidence <= 0.5:
+				best, bestConfidence = language[0], 0.5
+			}
+		}
+	}
+	if best != "" {
+		return best, bestConfidence
+	}
+
+	// Closest directory named after a language
+	dirs := strings.Split(strings.Trim(dir, "/"), "/")
+	for i := len(dirs) - 1; i >= 0; i-- {
+		lower := strings.ToLower(dirs[i])
+		for _, language := range subtitleLanguages {
+			if lower == language[3] {
+				return language[0], 0.6
+			}
+		}
+		if tag, ok := normaliseLanguageTag(dirs[i]); ok {
+			return tag, 0.6
+		}
+	}
+	return "", 0
+}
+
+// Normalise a 2 or 3 letter language code, or a BCP-47 tag with a known primary language, such as `pt-br`.
+func normaliseLanguageTag(token string) (tag string, ok bool) {
+	primary, rest, _ := strings.Cut(token, "-")
+	primary = strings.ToLower(primary)
+	for _, language := range subtitleLanguages {
+		if primary != language[0] && primary != language[1] && primary != language[2] {
+			continue
+		}
+		if rest == "" {
+			return language[0], true
+		}
+		subtags := strings.Split(rest, "-")
+		for i, subtag := range subtags {
+			switch len(subtag) {
+			case 2:
+				// Region
+				subtags[i] = strings.ToUpper(subtag)
+			case 4:
+				// Script
+				subtags[i] = strings.ToUpper(subtag[:1]) + strings.ToLower(subtag[1:])
+			default:
+				subtags[i] = strings.ToLower(subtag)
+			}
+		}
+		return language[0] + "-" + strings.Join(subtags, "-"), true
+	}
+	return "", false
+}
@@ -0,0 +1,32 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+var ErrFileLocked = errors.New("file is locked by another process")
+
+var errFileLockUnsupported = errors.New("file locking is not supported on this platform")
+
+// Take a shared lock on `file`, retrying until `timeout` while another process, such as a downloader still
+// writing it, holds an exclusive one, or until `ctx` is done.
+func lockFileShared(ctx context.Context, file *os.File, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLockFileShared(file)
+		if err != nil || locked {
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return ErrFileLocked
+		}
+		select {
+		case <-time.After(min(50*time.Millisecond, time.Until(deadline))):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
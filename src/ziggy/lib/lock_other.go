@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !windows
+
+package lib
+
+import "os"
+
+func tryLockFileShared(file *os.File) (locked bool, err error) {
+	return false, errFileLockUnsupported
+}
+
+func unlockFile(file *os.File) error {
+	return errFileLockUnsupported
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package lib
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Whole file, as writers lock whatever range they're writing.
+const lockRangeLow, lockRangeHigh = ^uint32(0), ^uint32(0)
+
+func tryLockFileShared(file *os.File) (locked bool, err error) {
+	err = windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_FAIL_IMMEDIATELY, 0, lockRangeLow, lockRangeHigh, &windows.Overlapped{})
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlockFile(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, lockRangeLow, lockRangeHigh, &windows.Overlapped{})
+}
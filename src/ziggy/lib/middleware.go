@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"net/http"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// HashCache keeps hashes of served files by their URL path, see `OSDBHashMiddleware()`.
+type HashCache interface {
+	Get(path string) (result HashResult, ok bool)
+	Set(path string, result HashResult)
+}
+
+type memoryHashCache struct {
+	mutex   sync.RWMutex
+	results map[string]HashResult
+}
+
+// Cache hashes in memory, for as long as the process runs.
+func NewMemoryHashCache() HashCache {
+	return &memoryHashCache{results: map[string]HashResult{}}
+}
+
+func (c *memoryHashCache) Get(path string) (HashResult, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	result, ok := c.results[path]
+	result.FromCache = ok
+	return result, ok
+}
+
+func (c *memoryHashCache) Set(path string, result HashResult) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.results[path] = result
+}
+
+// Queue hashing files on cache misses of every `OSDBHashMiddleware()`, started on first use.
+var middlewareQueue = sync.OnceValue(func() *HashQueue { return NewHashQueue(1) })
+
+// Advertise OSDB hashes of files served by `next` from the working directory, such as an
+// `http.FileServer(http.Dir("."))`, so clients don't have to compute them. Responses to files with a hash in `cache`
+// get `X-OSDB-Hash` and `X-OSDB-Size` headers, other files are hashed in the background so later responses have them.
+// Cached hashes are only used while the file's size and modification time are the same as when it was hashed.
+// All middleware made by this share a single background hasher, use `OSDBHashMiddlewareWithQueue()` to serve
+// another directory or control hashing.
+func OSDBHashMiddleware(next http.Handler, cache HashCache) http.Handler {
+	return OSDBHashMiddlewareWithQueue(next, cache, ".", middlewareQueue())
+}
+
+// Same as `OSDBHashMiddleware()`, for files served from the `root` directory, hashed on cache misses by `queue`.
+// Close `queue` once the middleware is no longer used, to stop its workers.
+func OSDBHashMiddlewareWithQueue(next http.Handler, cache HashCache, root string, queue *HashQueue) http.Handler {
+	var mutex sync.Mutex
+	pending := map[string]bool{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Leading slash makes `Clean()` drop any `..` that would escape `root`.
+		urlPath := pathpkg.Clean("/" + r.URL.Path)
+		filePath := filepath.Join(root, filepath.FromSlash(urlPath))
+		fi, err := os.Stat(filePath)
+		if err != nil || !fi.Mode().IsRegular() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if result, ok := cache.Get(urlPath); ok && result.FileSize == fi.Size() && result.ModTime.Equal(fi.ModTime()) {
+			w.Header().Set("X-OSDB-Hash", result.Hash)
+			w.Header().Set("X-OSDB-Size", strconv.FormatInt(result.FileSize, 10))
+		} else {
+			mutex.Lock()
+			if !pending[urlPath] {
+				pending[urlPath] = true
+				// Stat from before hashing, so a file changed meanwhile is hashed again next time.
+				modTime := fi.ModTime()
+				result := queue.Enqueue(filePath)
+				go func() {
+					if result := <-result; result.Err == nil {
+						result.ModTime = modTime
+						cache.Set(urlPath, result)
+					}
+					mutex.Lock()
+					delete(pending, urlPath)
+					mutex.Unlock()
+				}()
+			}
+			mutex.Unlock()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"uosc/bins/src/ziggy/lib/testutil"
+)
+
+func TestOSDBHashMiddleware(t *testing.T) {
+	root := t.TempDir()
+	const size = 200000
+	path := filepath.Join(root, "movie.mkv")
+	if err := os.WriteFile(path, readSyntheticFile(t, size), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("working directory", func(t *testing.T) {
+		wd, err := os.Getwd()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chdir(root); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chdir(wd)
+
+		server := httptest.NewServer(OSDBHashMiddleware(http.FileServer(http.Dir(".")), NewMemoryHashCache()))
+		defer server.Close()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			res, err := http.Head(server.URL + "/movie.mkv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if hash := res.Header.Get("X-OSDB-Hash"); hash == testutil.KnownHash(size) {
+				break
+			} else if hash != "" || time.Now().After(deadline) {
+				t.Fatalf("hash is %q, expected %s", hash, testutil.KnownHash(size))
+			}
+		}
+	})
+	queue := NewHashQueue(1)
+	defer queue.Close()
+	server := httptest.NewServer(OSDBHashMiddlewareWithQueue(http.FileServer(http.Dir(root)), NewMemoryHashCache(), root, queue))
+	defer server.Close()
+
+	// Hashing happens in the background, so wait for a response to have it.
+	waitForHash := func(urlPath string) http.Header {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			res, err := http.Head(server.URL + urlPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.Header.Get("X-OSDB-Hash") != "" {
+				return res.Header
+			}
+		}
+		t.Fatalf("%s never got a hash", urlPath)
+		return nil
+	}
+
+	header := waitForHash("/movie.mkv")
+	if hash := header.Get("X-OSDB-Hash"); hash != testutil.KnownHash(size) {
+		t.Errorf("hash is %s, expected %s", hash, testutil.KnownHash(size))
+	}
+	if fileSize := header.Get("X-OSDB-Size"); fileSize != strconv.Itoa(size) {
+		t.Errorf("size is %s, expected %d", fileSize, size)
+	}
+	if header := waitForHash("/../movie.mkv"); header.Get("X-OSDB-Hash") != testutil.KnownHash(size) {
+		t.Error("path with .. didn't get the same hash")
+	}
+
+	// Same size, other contents, as when a file is re-muxed in place.
+	other := readSyntheticFile(t, size+1)[:size]
+	if err := os.WriteFile(path, other, 0666); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := OSDBHashBytes(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		hash := waitForHash("/movie.mkv").Get("X-OSDB-Hash")
+		if hash == expected {
+			break
+		}
+		if hash != testutil.KnownHash(size) || time.Now().After(deadline) {
+			t.Fatalf("hash is %s, expected %s once rehashed", hash, expected)
+		}
+	}
+
+	t.Run("not hashed", func(t *testing.T) {
+		for _, req := range []struct{ method, path string }{
+			{"GET", "/missing.mkv"},
+			{"GET", "/"},
+			{"POST", "/movie.mkv"},
+		} {
+			r, _ := http.NewRequest(req.method, server.URL+req.path, nil)
+			res, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.Header.Get("X-OSDB-Hash") != "" {
+				t.Errorf("%s %s has a hash", req.method, req.path)
+			}
+		}
+	})
+}
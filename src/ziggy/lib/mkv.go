@@ -0,0 +1,306 @@
+package lib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"strings"
+)
+
+// Matroska element IDs, with their length markers kept.
+const (
+	ebmlIDHeader           = 0x1A45DFA3
+	ebmlIDDocType          = 0x4282
+	ebmlIDSegment          = 0x18538067
+	ebmlIDInfo             = 0x1549A966
+	ebmlIDCluster          = 0x1F43B675
+	ebmlIDTimecodeScale    = 0x2AD7B1
+	ebmlIDDuration         = 0x4489
+	ebmlIDChapters         = 0x1043A770
+	ebmlIDEditionEntry     = 0x45B9
+	ebmlIDChapterAtom      = 0xB6
+	ebmlIDChapterTimeStart = 0x91
+	ebmlIDChapterTimeEnd   = 0x92
+	ebmlIDTracks           = 0x1654AE6B
+	ebmlIDTrackEntry       = 0xAE
+	ebmlIDTrackType        = 0x83
+	ebmlIDCodecID          = 0x86
+	ebmlIDVideo            = 0xE0
+	ebmlIDPixelWidth       = 0xB0
+	ebmlIDPixelHeight      = 0xBA
+)
+
+type ebmlElement struct {
+	id         uint32
+	dataOffset int64
+	// -1 when the size is unknown, which is common for segments and clusters of live recordings.
+	size int64
+}
+
+func (el ebmlElement) end(parentEnd int64) int64 {
+	if el.size < 0 {
+		return parentEnd
+	}
+	return el.dataOffset + el.size
+}
+
+func readEBMLElement(r io.ReaderAt, offset int64) (el ebmlElement, err error) {
+	// Longest possible header is a 4 byte ID followed by an 8 byte size.
+	var header [12]byte
+	n, err := r.ReadAt(header[:], offset)
+	if n == 0 && err != nil {
+		return el, err
+	}
+	data := header[:n]
+
+	idLength := bits.LeadingZeros8(data[0]) + 1
+	if idLength > 4 || idLength >= len(data) {
+		return el, fmt.Errorf("invalid EBML element ID at %v", offset)
+	}
+	for _, b := range data[:idLength] {
+		el.id = el.id<<8 | uint32(b)
+	}
+
+	data = data[idLength:]
+	if data[0] == 0 {
+		return el, fmt.Errorf("invalid EBML element size at %v", offset)
+	}
+	sizeLength := bits.LeadingZeros8(data[0]) + 1
+	if sizeLength > len(data) {
+		return el, fmt.Errorf("truncated EBML element size at %v", offset)
+	}
+	size := uint64(data[0]) & (0xFF >> sizeLength)
+	allOnes := size == 0xFF>>sizeLength
+	for _, b := range data[1:sizeLength] {
+		size = size<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+
+	el.dataOffset = offset + int64(idLength+sizeLength)
+	el.size = int64(size)
+	if allOnes {
+		el.size = -1
+	}
+	return el, nil
+}
+
+// Call `fn` for each child element in the `[start, end)` range of a master element.
+// Returning `io.EOF` from `fn` stops the iteration without an error.
+func forEachEBMLChild(r io.ReaderAt, start, end int64, fn func(el ebmlElement) error) error {
+	for offset := start; offset < end; {
+		el, err := readEBMLElement(r, offset)
+		if err != nil {
+			return err
+		}
+		// Only master elements can have unknown sizes, and they extend to the end of their parent. Streaming muxers
+		// write clusters like that, which are taken as running to the end too, skipping later ones.
+		if el.size < 0 && el.id != ebmlIDSegment && el.id != ebmlIDCluster {
+			return fmt.Errorf("unsupported unknown-size element %x at %v", el.id, offset)
+		}
+		if err := fn(el); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		offset = el.end(end)
+	}
+	return nil
+}
+
+func readEBMLData(r io.ReaderAt, el ebmlElement) ([]byte, error) {
+	if el.size < 0 || el.size > 8 {
+		return nil, fmt.Errorf("invalid EBML value size %v at %v", el.size, el.dataOffset)
+	}
+	data := make([]byte, el.size)
+	if _, err := r.ReadAt(data, el.dataOffset); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func readEBMLString(r io.ReaderAt, el ebmlElement) (string, error) {
+	// Strings we care about are short identifiers.
+	if el.size < 0 || el.size > 256 {
+		return "", fmt.Errorf("invalid EBML string size %v at %v", el.size, el.dataOffset)
+	}
+	data := make([]byte, el.size)
+	if _, err := r.ReadAt(data, el.dataOffset); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\x00"), nil
+}
+
+func readEBMLUint(r io.ReaderAt, el ebmlElement) (uint64, error) {
+	data, err := readEBMLData(r, el)
+	if err != nil {
+		return 0, err
+	}
+	value := uint64(0)
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value, nil
+}
+
+func readEBMLFloat(r io.ReaderAt, el ebmlElement) (float64, error) {
+	data, err := readEBMLData(r, el)
+	if err != nil {
+		return 0, err
+	}
+	switch len(data) {
+	case 0:
+		return 0, nil
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), nil
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), nil
+	}
+	return 0, fmt.Errorf("invalid EBML float size %v at %v", len(data), el.dataOffset)
+}
+
+// Locate the first segment of a matroska file, erroring when the file doesn't start with an EBML header.
+func findMKVSegment(r io.ReaderAt, fileSize int64) (segment ebmlElement, err error) {
+	header, err := readEBMLElement(r, 0)
+	if err != nil || header.id != ebmlIDHeader {
+		return segment, errors.New("not a matroska file")
+	}
+
+	found := false
+	err = forEachEBMLChild(r, 0, fileSize, func(el ebmlElement) error {
+		if el.id == ebmlIDSegment {
+			segment, found = el, true
+			return io.EOF
+		}
+		return nil
+	})
+	if err == nil && !found {
+		err = errors.New("matroska file has no segment")
+	}
+	return segment, err
+}
+
+type mkvChapter struct {
+	// Nanoseconds, end is 0 when not specified.
+	start, end uint64
+}
+
+// Generate an OSDB hash for the approximate byte range of a chapter in the first edition of an MKV file.
+// The range is estimated from chapter timestamps assuming a constant bitrate over the whole segment,
+// so it's only stable for the same file, not across different encodes.
+func OSDBHashMKVChapter(filePath string, chapterIndex int) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", errors.New("couldn't open file for hashing")
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return "", errors.New("couldn't stat file for hashing")
+	}
+	fileSize := fi.Size()
+
+	segment, err := findMKVSegment(file, fileSize)
+	if err != nil {
+		return "", err
+	}
+	segmentEnd := segment.end(fileSize)
+
+	timecodeScale := uint64(1000000)
+	duration := float64(0)
+	chapters := []mkvChapter{}
+	seenInfo, seenChapters := false, false
+	err = forEachEBMLChild(file, segment.dataOffset, segmentEnd, func(el ebmlElement) (err error) {
+		switch el.id {
+		case ebmlIDInfo:
+			timecodeScale, duration, err = readMKVInfo(file, el)
+			seenInfo = true
+		case ebmlIDChapters:
+			chapters, err = readMKVChapters(file, el)
+			seenChapters = true
+		}
+		// No need to walk all the clusters that follow.
+		if err == nil && seenInfo && seenChapters {
+			return io.EOF
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if chapterIndex < 0 || chapterIndex >= len(chapters) {
+		return "", fmt.Errorf("chapter %v doesn't exist, file has %v chapters", chapterIndex, len(chapters))
+	}
+	durationNs := duration * float64(timecodeScale)
+	if durationNs <= 0 {
+		return "", errors.New("file doesn't specify its duration")
+	}
+
+	chapter := chapters[chapterIndex]
+	end := float64(chapter.end)
+	if chapter.end == 0 {
+		end = durationNs
+		if chapterIndex+1 < len(chapters) {
+			end = float64(chapters[chapterIndex+1].start)
+		}
+	}
+
+	segmentSize := float64(segmentEnd - segment.dataOffset)
+	toOffset := func(ns float64) int64 {
+		return segment.dataOffset + int64(segmentSize*min(ns/durationNs, 1))
+	}
+
+	return OSDBHashFileRange(filePath, toOffset(float64(chapter.start)), toOffset(end))
+}
+
+// Timecode scale in nanoseconds, and segment duration in timecode scale units.
+func readMKVInfo(r io.ReaderAt, info ebmlElement) (timecodeScale uint64, duration float64, err error) {
+	timecodeScale = 1000000
+	err = forEachEBMLChild(r, info.dataOffset, info.end(0), func(el ebmlElement) (err error) {
+		switch el.id {
+		case ebmlIDTimecodeScale:
+			timecodeScale, err = readEBMLUint(r, el)
+		case ebmlIDDuration:
+			duration, err = readEBMLFloat(r, el)
+		}
+		return err
+	})
+	return timecodeScale, duration, err
+}
+
+// Top level chapters of the first edition.
+func readMKVChapters(r io.ReaderAt, chaptersElement ebmlElement) (chapters []mkvChapter, err error) {
+	err = forEachEBMLChild(r, chaptersElement.dataOffset, chaptersElement.end(0), func(edition ebmlElement) error {
+		if edition.id != ebmlIDEditionEntry {
+			return nil
+		}
+		err := forEachEBMLChild(r, edition.dataOffset, edition.end(0), func(atom ebmlElement) error {
+			if atom.id != ebmlIDChapterAtom {
+				return nil
+			}
+			chapter := mkvChapter{}
+			err := forEachEBMLChild(r, atom.dataOffset, atom.end(0), func(el ebmlElement) (err error) {
+				switch el.id {
+				case ebmlIDChapterTimeStart:
+					chapter.start, err = readEBMLUint(r, el)
+				case ebmlIDChapterTimeEnd:
+					chapter.end, err = readEBMLUint(r, el)
+				}
+				return err
+			})
+			chapters = append(chapters, chapter)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return io.EOF
+	})
+	return chapters, err
+}
@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// EBML element with an 8 byte size, or an unknown size when `size` is -1.
+func ebmlSized(id uint32, size int64, data ...[]byte) []byte {
+	var idBytes [4]byte
+	binary.BigEndian.PutUint32(idBytes[:], id)
+	el := bytes.TrimLeft(idBytes[:], "\x00")
+
+	sizeBytes := binary.BigEndian.AppendUint64(nil, uint64(size))
+	sizeBytes[0] = 0x01
+	el = append(el, sizeBytes...)
+	return append(el, bytes.Join(data, nil)...)
+}
+
+func ebml(id uint32, data ...[]byte) []byte {
+	return ebmlSized(id, int64(len(bytes.Join(data, nil))), data...)
+}
+
+func ebmlUnknownSize(id uint32, data ...[]byte) []byte {
+	return ebmlSized(id, -1, data...)
+}
+
+func ebmlUint(id uint32, value uint64) []byte {
+	return ebml(id, binary.BigEndian.AppendUint64(nil, value))
+}
+
+func ebmlFloat(id uint32, value float64) []byte {
+	return ebml(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(value)))
+}
+
+func TestReadEBMLElement(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected ebmlElement
+		wantErr  bool
+	}{
+		{name: "one byte ID and size", data: []byte{0x83, 0x81, 0x01}, expected: ebmlElement{0x83, 2, 1}},
+		{name: "two byte size", data: []byte{0x83, 0x40, 0x80}, expected: ebmlElement{0x83, 3, 0x80}},
+		{name: "four byte ID", data: []byte{0x18, 0x53, 0x80, 0x67, 0x82}, expected: ebmlElement{ebmlIDSegment, 5, 2}},
+		{name: "unknown one byte size", data: []byte{0x18, 0x53, 0x80, 0x67, 0xFF}, expected: ebmlElement{ebmlIDSegment, 5, -1}},
+		{name: "unknown eight byte size", data: ebmlUnknownSize(ebmlIDCluster), expected: ebmlElement{ebmlIDCluster, 12, -1}},
+		{name: "eight byte size", data: ebml(ebmlIDCluster, make([]byte, 300)), expected: ebmlElement{ebmlIDCluster, 12, 300}},
+		{name: "invalid ID", data: []byte{0x00, 0x81, 0x01}, wantErr: true},
+		{name: "invalid size", data: []byte{0x83, 0x00, 0x01}, wantErr: true},
+		{name: "truncated size", data: []byte{0x83, 0x10, 0x00}, wantErr: true},
+		{name: "truncated ID", data: []byte{0x18, 0x53}, wantErr: true},
+		{name: "empty", data: []byte{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			el, err := readEBMLElement(bytes.NewReader(tt.data), 0)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("succeeded with %+v", el)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if el != tt.expected {
+				t.Errorf("got %+v, expected %+v", el, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOSDBHashMKVChapter(t *testing.T) {
+	header := ebml(ebmlIDHeader, ebml(ebmlIDDocType, []byte("matroska")))
+	info := ebml(ebmlIDInfo, ebmlUint(ebmlIDTimecodeScale, 1000000), ebmlFloat(ebmlIDDuration, 10000))
+	chapters := ebml(ebmlIDChapters, ebml(ebmlIDEditionEntry,
+		ebml(ebmlIDChapterAtom, ebmlUint(ebmlIDChapterTimeStart, 0)),
+		ebml(ebmlIDChapterAtom, ebmlUint(ebmlIDChapterTimeStart, 4e9), ebmlUint(ebmlIDChapterTimeEnd, 6e9)),
+		ebml(ebmlIDChapterAtom, ebmlUint(ebmlIDChapterTimeStart, 6e9)),
+	))
+	// Second edition is ignored
+	otherEdition := ebml(ebmlIDEditionEntry, ebml(ebmlIDChapterAtom, ebmlUint(ebmlIDChapterTimeStart, 1e9)))
+	blocks := readSyntheticFile(t, 1<<20)
+	cluster := ebml(ebmlIDCluster, ebml(0xA3, blocks))
+
+	tests := []struct {
+		name    string
+		chapter int
+		segment []byte
+		// Fractions of the segment the chapter covers.
+		start, end float64
+		wantErr    bool
+	}{
+		{
+			name:    "first chapter ends where the next starts",
+			segment: ebml(ebmlIDSegment, info, chapters, cluster),
+			chapter: 0, start: 0, end: 0.4,
+		},
+		{
+			name:    "chapter with an end",
+			segment: ebml(ebmlIDSegment, info, chapters, cluster),
+			chapter: 1, start: 0.4, end: 0.6,
+		},
+		{
+			name:    "last chapter ends with the segment",
+			segment: ebml(ebmlIDSegment, info, chapters, cluster),
+			chapter: 2, start: 0.6, end: 1,
+		},
+		{
+			name:    "chapters before info",
+			segment: ebml(ebmlIDSegment, chapters, info, cluster),
+			chapter: 1, start: 0.4, end: 0.6,
+		},
+		{
+			name:    "first edition only",
+			segment: ebml(ebmlIDSegment, info, ebml(ebmlIDChapters, chapters[12:], otherEdition), cluster),
+			chapter: 1, start: 0.4, end: 0.6,
+		},
+		{
+			name:    "unknown-size segment",
+			segment: ebmlUnknownSize(ebmlIDSegment, info, chapters, cluster),
+			chapter: 1, start: 0.4, end: 0.6,
+		},
+		{
+			name: "unknown-size clusters",
+			segment: ebmlUnknownSize(ebmlIDSegment, info, chapters,
+				ebmlUnknownSize(ebmlIDCluster, ebml(0xA3, blocks[:len(blocks)/2])),
+				ebmlUnknownSize(ebmlIDCluster, ebml(0xA3, blocks[len(blocks)/2:])),
+			),
+			chapter: 1, start: 0.4, end: 0.6,
+		},
+		{
+			name:    "chapter that doesn't exist",
+			segment: ebml(ebmlIDSegment, info, chapters, cluster),
+			chapter: 3, wantErr: true,
+		},
+		{
+			name:    "negative chapter",
+			segment: ebml(ebmlIDSegment, info, chapters, cluster),
+			chapter: -1, wantErr: true,
+		},
+		{
+			name:    "no chapters",
+			segment: ebml(ebmlIDSegment, info, cluster),
+			chapter: 0, wantErr: true,
+		},
+		{
+			name:    "no duration",
+			segment: ebml(ebmlIDSegment, ebml(ebmlIDInfo, ebmlUint(ebmlIDTimecodeScale, 1000000)), chapters, cluster),
+			chapter: 0, wantErr: true,
+		},
+		{
+			name:    "unknown-size info",
+			segment: ebml(ebmlIDSegment, ebmlUnknownSize(ebmlIDInfo, info[12:]), chapters, cluster),
+			chapter: 0, wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := append(append([]byte{}, header...), tt.segment...)
+			path := filepath.Join(t.TempDir(), "chapters.mkv")
+			if err := os.WriteFile(path, data, 0666); err != nil {
+				t.Fatal(err)
+			}
+
+			hash, err := OSDBHashMKVChapter(path, tt.chapter)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("succeeded with %s", hash)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			segmentStart := len(header) + 12
+			segmentSize := float64(len(tt.segment) - 12)
+			start := segmentStart + int(segmentSize*tt.start)
+			end := segmentStart + int(segmentSize*tt.end)
+			expected, err := OSDBHashBytes(data[start:end])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != expected {
+				t.Errorf("hash is %s, expected %s of [%d, %d)", hash, expected, start, end)
+			}
+		})
+	}
+}
+
+func TestOSDBHashMKVChapterNotMatroska(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video.mkv")
+	if err := os.WriteFile(path, readSyntheticFile(t, 1<<20)[4:], 0666); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := OSDBHashMKVChapter(path, 0); err == nil {
+		t.Errorf("succeeded with %s", hash)
+	}
+}
@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Movie metadata stored in Kodi `.nfo` files.
+type MovieInfo struct {
+	Title string `json:"title"`
+	Year  int    `json:"year"`
+	// Such as `tt0133093`.
+	IMDBID     string        `json:"imdb_id"`
+	VideoCodec string        `json:"video_codec"`
+	AudioCodec string        `json:"audio_codec"`
+	Width      int           `json:"width"`
+	Height     int           `json:"height"`
+	Duration   time.Duration `json:"duration"`
+}
+
+type nfoUniqueID struct {
+	Type    string `xml:"type,attr"`
+	Default bool   `xml:"default,attr,omitempty"`
+	Value   string `xml:",chardata"`
+}
+
+type nfoMovie struct {
+	XMLName   xml.Name      `xml:"movie"`
+	Title     string        `xml:"title,omitempty"`
+	Year      int           `xml:"year,omitempty"`
+	UniqueIDs []nfoUniqueID `xml:"uniqueid"`
+	Video     struct {
+		Codec    string `xml:"codec,omitempty"`
+		Width    int    `xml:"width,omitempty"`
+		Height   int    `xml:"height,omitempty"`
+		Duration int64  `xml:"durationinseconds,omitempty"`
+	} `xml:"fileinfo>streamdetails>video"`
+	Audio struct {
+		Codec string `xml:"codec,omitempty"`
+	} `xml:"fileinfo>streamdetails>audio"`
+	// Not a Kodi element, Kodi ignores elements it doesn't know.
+	OSDBHash struct {
+		Size  int64  `xml:"size,attr,omitempty"`
+		Value string `xml:",chardata"`
+	} `xml:"osdbhash"`
+}
+
+// Path of the `.nfo` file next to a media file, `movie.mkv` has its metadata in `movie.nfo`.
+func nfoPath(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".nfo") {
+		return path
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".nfo"
+}
+
+// Save `info` and the file's hash into an `.nfo` file next to the media file at `path`, replacing an existing one.
+func ExportNFO(path string, result HashResult, info MovieInfo) error {
+	movie := nfoMovie{Title: info.Title, Year: info.Year}
+	if info.IMDBID != "" {
+		movie.UniqueIDs = []nfoUniqueID{{Type: "imdb", Default: true, Value: info.IMDBID}}
+	}
+	movie.Video.Codec = info.VideoCodec
+	movie.Video.Width = info.Width
+	movie.Video.Height = info.Height
+	movie.Video.Duration = int64(info.Duration.Seconds())
+	movie.Audio.Codec = info.AudioCodec
+	movie.OSDBHash.Size = result.FileSize
+	movie.OSDBHash.Value = result.Hash
+
+	data, err := xml.MarshalIndent(movie, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), data...)
+	return WriteAtomically(nfoPath(path), append(data, '\n'), 0o644)
+}
+
+// Read metadata and hash saved by `ExportNFO()`, `path` can be the media file or its `.nfo` file.
+func ParseNFO(path string) (MovieInfo, HashResult, error) {
+	data, err := os.ReadFile(nfoPath(path))
+	if err != nil {
+		return MovieInfo{}, HashResult{}, errors.New("couldn't read nfo file")
+	}
+
+	var movie nfoMovie
+	if err := xml.Unmarshal(data, &movie); err != nil {
+		return MovieInfo{}, HashResult{}, err
+	}
+
+	info := MovieInfo{
+		Title:      movie.Title,
+		Year:       movie.Year,
+		VideoCodec: movie.Video.Codec,
+		AudioCodec: movie.Audio.Codec,
+		Width:      movie.Video.Width,
+		Height:     movie.Video.Height,
+		Duration:   time.Duration(movie.Video.Duration) * time.Second,
+	}
+	for _, id := range movie.UniqueIDs {
+		if id.Type == "imdb" {
+			info.IMDBID = strings.TrimSpace(id.Value)
+			break
+		}
+	}
+	result := HashResult{Hash: strings.TrimSpace(movie.OSDBHash.Value), FileSize: movie.OSDBHash.Size, FromCache: true}
+	return info, result, nil
+}
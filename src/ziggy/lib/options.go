@@ -0,0 +1,271 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// HashConfig controls how a file or URL is read for hashing. Set fields with `Option` functions, or build one with
+// the fluent methods of the same names, such as `HashConfig{}.WithConnectTimeout(5 * time.Second).WithReadAhead()`,
+// and pass it with `WithConfig()`. Each method is the same as its `Option` function, see those for details.
+type HashConfig struct {
+	// Deadline for the whole remote read, including the initial HEAD request.
+	Timeout time.Duration
+	// Deadline for establishing each connection to a remote server.
+	ConnectTimeout time.Duration
+	// Deadline for each request to a remote server, from sending it to reading its whole body.
+	ReadTimeout time.Duration
+	// Most connections open to, and chunk reads running at once from, a single remote host, across all reads with
+	// the same settings.
+	MaxConnsPerHost int
+	// Extra requests for a remote chunk after its response fails part way.
+	MaxRetries int
+	// Opens connections to remote servers instead of `net.Dialer`.
+	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Receives a JSON line for every ranged request made to a remote file.
+	RangeLog io.Writer
+	// Request all remote chunks at once instead of one after another.
+	ReadAhead bool
+	// Remembers chunks of remote files, so they're only re-read when the file's ETag changes.
+	ETagStore ETagStore
+	// Size of a remote file, used when the server doesn't send `Content-Length`.
+	SizeHint int64
+	// Check robots.txt and `X-Robots-Tag` before reading remote files.
+	RespectRobots bool
+	// Biggest remote file downloaded whole when its server doesn't support range requests.
+	RangeFallbackSizeLimit int64
+	// JSON lines file every `OSDBHashFile()` call is recorded in.
+	AuditLog string
+	// User recorded in the audit log, `$USER` when empty.
+	AuditUser string
+	// Read chunks one after another, in order of their offsets.
+	DeterministicOrder bool
+	// How long to wait for a shared lock on local files before reading them, no locking when 0.
+	FileLockTimeout time.Duration
+	// Stop batch operations such as `BitRotScan()` at the first error.
+	FailFast bool
+	// Count chunk reads for `DumpReadStats()`.
+	ReadStats bool
+	// Stops reads once done, nil for reads that aren't cancelled.
+	Context context.Context
+
+	// Pre-allocated buffer to read chunks into, see `OSDBHashInto()`.
+	buffer []byte
+	// Reading segments of an MPD manifest, which mustn't be taken for manifests themselves.
+	inMPD bool
+	// Set when chunks come from `ETagStore`, for `HashResult.FromCache`.
+	fromCache *bool
+}
+
+// Options is the name `HashConfig` had before it got a fluent builder.
+type Options = HashConfig
+
+type Option func(*HashConfig)
+
+// Override the default 10 second deadline for remote reads.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.Timeout = timeout
+	}
+}
+
+// Give up on connecting to a remote server after `timeout`, so unreachable servers fail fast even if reads
+// are given more time.
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.ConnectTimeout = timeout
+	}
+}
+
+// Give up on each remote request, including reading its response, after `timeout`.
+// Deadline of `WithTimeout()` still applies to the whole read, so raise it too for slow links.
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.ReadTimeout = timeout
+	}
+}
+
+// Limit how many connections are open to a single host at once, and how many chunk reads from it run at once, across
+// all concurrent reads with the same options, so hashing many files from one CDN doesn't trip its rate limiting.
+// Reads of registered URL schemes are limited by their `scheme://host` too. Defaults to 4, 0 or less means no limit.
+func WithMaxConnsPerHost(n int) Option {
+	return func(o *Options) {
+		o.MaxConnsPerHost = n
+	}
+}
+
+// Send the request for a remote chunk again up to `n` times when its response fails part way, instead of the default
+// 2 times. The whole chunk is requested again, so chunks bigger than `2*OSDBChunkSize` can only be retried before any
+// of their bytes are read. 0 or less means failures aren't retried.
+func WithMaxRetries(n int) Option {
+	return func(o *Options) {
+		o.MaxRetries = n
+	}
+}
+
+// Open connections to remote servers with `dial`, such as to bind a specific local address, tunnel through a
+// SOCKS5 proxy, or connect to a fake server in tests. TLS is still done by the HTTP client on top of the connection,
+// and the timeout of `WithConnectTimeout()` still applies.
+func WithDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(o *Options) {
+		o.Dialer = dial
+	}
+}
+
+// Connect to remote servers through the SOCKS5 proxy at `addr`, such as Tor's `127.0.0.1:9050`, authenticating
+// with `user` and `pass` unless `user` is empty. Host names are resolved by the proxy. Replaces `WithDialer()`.
+func WithSOCKS5Proxy(addr, user, pass string) Option {
+	return func(o *Options) {
+		var auth *proxy.Auth
+		if user != "" {
+			auth = &proxy.Auth{User: user, Password: pass}
+		}
+		dialer, err := proxy.SOCKS5("tcp", addr, auth, &net.Dialer{KeepAlive: 30 * time.Second})
+		if err != nil {
+			o.Dialer = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return nil, err
+			}
+			return
+		}
+		o.Dialer = dialer.(proxy.ContextDialer).DialContext
+	}
+}
+
+// Append a `{"url":"…","range_start":0,"range_end":65535,"ms":12}` JSON line to `w` after each remote chunk read.
+// Whole files downloaded from servers without range support get a line with a `warning` instead.
+func WithRangeLog(w io.Writer) Option {
+	return func(o *Options) {
+		o.RangeLog = w
+	}
+}
+
+// Start fetching the tail chunk of a remote file right away, without waiting for the head chunk to arrive,
+// so that both round-trips overlap.
+func WithReadAhead() Option {
+	return func(o *Options) {
+		o.ReadAhead = true
+	}
+}
+
+// Send `If-None-Match` with the ETag of chunks cached in `store`, and reuse them when the server responds with 304.
+// Chunks read from files with an ETag are stored back into `store`.
+func WithETagCache(store ETagStore) Option {
+	return func(o *Options) {
+		o.ETagStore = store
+	}
+}
+
+// Assume a remote file is `size` bytes long when the server doesn't send `Content-Length`, as is common with
+// chunked transfer encoding. Chunks are read at offsets based on `size`, so the hash is only valid
+// if it matches the actual file size.
+func WithSizeHint(size int64) Option {
+	return func(o *Options) {
+		o.SizeHint = size
+	}
+}
+
+// Fetch robots.txt of a remote file's host before reading the file, and fail with `ErrDisallowed` when it, or
+// the file's `X-Robots-Tag` header, disallows us. robots.txt is fetched once per host, and is off by default.
+func WithRespectRobots(respect bool) Option {
+	return func(o *Options) {
+		o.RespectRobots = respect
+	}
+}
+
+// Override the default 10 MB limit of remote files that are downloaded whole when their server doesn't support range
+// requests. Pass 0 to fail with `ErrRangeNotSupported` instead.
+func WithRangeFallbackSizeLimit(limit int64) Option {
+	return func(o *Options) {
+		o.RangeFallbackSizeLimit = limit
+	}
+}
+
+// Append a `{"ts":"…","path":"…","hash":"…","user":"…","pid":1234,"duration_ms":12}` JSON line to the file at `path`
+// for every `OSDBHashFile()` call. Hashing fails when the entry can't be written.
+func WithAuditLog(path string) Option {
+	return func(o *Options) {
+		o.AuditLog = path
+	}
+}
+
+// Record `user` in the audit log instead of `$USER`.
+func WithAuditUser(user string) Option {
+	return func(o *Options) {
+		o.AuditUser = user
+	}
+}
+
+// Read chunks one after another in order of their offsets in the file, so traces of reads are the same on every run.
+// Makes no difference for the standard head and tail chunks, but does for custom chunk layouts.
+// Takes precedence over `WithReadAhead()`.
+func WithDeterministicOrder() Option {
+	return func(o *Options) {
+		o.DeterministicOrder = true
+	}
+}
+
+// Take a shared lock (`flock()` on Linux and macOS, `LockFileEx()` on Windows) on local files before reading them,
+// waiting up to `timeout` for it, and fail with `ErrFileLocked` if it's not acquired in time. Keeps files that are
+// still being written from being hashed with their incomplete size, as long as the writer holds an exclusive lock.
+func WithFileLock(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.FileLockTimeout = timeout
+	}
+}
+
+// Stop batch operations such as `BitRotScan()` at the first file that can't be hashed, instead of reporting all
+// errors once every file is checked.
+func WithFailFast() Option {
+	return func(o *Options) {
+		o.FailFast = true
+	}
+}
+
+// Count chunk reads in `DumpReadStats()`, by file path or URL.
+func WithReadStats() Option {
+	return func(o *Options) {
+		o.ReadStats = true
+	}
+}
+
+// Stop reading, and fail with the context's error, once `ctx` is done. Remote requests are cancelled right away,
+// local files stop being read before their next chunk.
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.Context = ctx
+	}
+}
+
+func optionsContext(opts Options) context.Context {
+	if opts.Context == nil {
+		return context.Background()
+	}
+	return opts.Context
+}
+
+func newOptions(opts []Option) Options {
+	options := Options{
+		Timeout:                10 * time.Second,
+		RangeFallbackSizeLimit: 10 * 1024 * 1024,
+		MaxConnsPerHost:        4,
+		MaxRetries:             rewindRetries,
+	}
+	global := GetGlobalOptions()
+	if global.Timeout != 0 {
+		options.Timeout = global.Timeout
+	}
+	if global.RangeFallbackSizeLimit != 0 {
+		options.RangeFallbackSizeLimit = global.RangeFallbackSizeLimit
+	}
+	if global.MaxConnsPerHost != 0 {
+		options.MaxConnsPerHost = global.MaxConnsPerHost
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
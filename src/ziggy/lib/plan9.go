@@ -0,0 +1,250 @@
+package lib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 9P2000 message types, only the ones needed to read a file.
+const (
+	p9Tversion = 100
+	p9Tattach  = 104
+	p9Rerror   = 107
+	p9Twalk    = 110
+	p9Topen    = 112
+	p9Tread    = 116
+	p9Tstat    = 124
+)
+
+const (
+	p9NoTag = 0xFFFF
+	p9NoFid = 0xFFFFFFFF
+	// Biggest message asked for, enough for a whole 64k chunk in one read.
+	p9MaxMessageSize = 128 * 1024
+	// Size of the Rread header, before its data.
+	p9ReadHeaderSize = 4 + 1 + 2 + 4
+	// Most names a single Twalk can take, paths are walked in one go so they can't be any deeper.
+	p9MaxWalkNames = 16
+)
+
+const (
+	p9RootFid = 1
+	p9FileFid = 2
+)
+
+type plan9ChunkReader struct {
+	addr, path string
+	timeout    time.Duration
+
+	mutex sync.Mutex
+	conn  net.Conn
+	// Negotiated with the server in Tversion.
+	messageSize uint32
+	ioUnit      uint32
+}
+
+// Read a file from a 9P2000 server, such as a NAS export or a container's file system, at `addr` (`host:port` over
+// TCP, or `unix:/path/to/socket`). The connection is made on first use, attaching as user `none` to the default tree,
+// and each chunk is read with `Tread` requests. Paths can be at most 16 names deep.
+func NewPlan9ChunkReader(addr, path string) ChunkReader {
+	return &plan9ChunkReader{addr: addr, path: path, timeout: newOptions(nil).Timeout}
+}
+
+func (r *plan9ChunkReader) Size() (int64, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.connect(); err != nil {
+		return 0, err
+	}
+
+	res, err := r.rpc(p9Tstat, p9Message{}.uint32(p9FileFid))
+	if err != nil {
+		return 0, err
+	}
+	// Rstat is n[2] stat[n], and stat is size[2] type[2] dev[4] qid[13] mode[4] atime[4] mtime[4] length[8] ...
+	if len(res) < 2+41 {
+		return 0, errors.New("9P server sent a short Rstat")
+	}
+	return int64(binary.LittleEndian.Uint64(res[2+33:])), nil
+}
+
+func (r *plan9ChunkReader) ReadChunk(offset int64, buf []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.connect(); err != nil {
+		return err
+	}
+
+	maxCount := r.messageSize - p9ReadHeaderSize
+	if r.ioUnit > 0 {
+		maxCount = min(maxCount, r.ioUnit)
+	}
+	for len(buf) > 0 {
+		count := min(uint32(len(buf)), maxCount)
+		res, err := r.rpc(p9Tread, p9Message{}.uint32(p9FileFid).uint64(uint64(offset)).uint32(count))
+		if err != nil {
+			return err
+		}
+		if len(res) < 4 {
+			return errors.New("9P server sent a short Rread")
+		}
+		n := int(binary.LittleEndian.Uint32(res))
+		if n == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if n > len(res)-4 || n > len(buf) {
+			return errors.New("9P server sent a malformed Rread")
+		}
+		copy(buf, res[4:4+n])
+		buf = buf[n:]
+		offset += int64(n)
+	}
+	return nil
+}
+
+func (r *plan9ChunkReader) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	// Servers clunk all fids of a connection once it's closed.
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+// Dial the server, and walk to and open the file, unless that's done already.
+func (r *plan9ChunkReader) connect() (err error) {
+	if r.conn != nil {
+		return nil
+	}
+
+	network, address := "tcp", r.addr
+	if socket, ok := strings.CutPrefix(r.addr, "unix:"); ok {
+		network, address = "unix", socket
+	}
+	r.conn, err = net.DialTimeout(network, address, r.timeout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			r.conn.Close()
+			r.conn = nil
+		}
+	}()
+
+	r.messageSize = p9MaxMessageSize
+	res, err := r.rpc(p9Tversion, p9Message{}.uint32(p9MaxMessageSize).string("9P2000"))
+	if err != nil {
+		return err
+	}
+	if len(res) < 4 {
+		return errors.New("9P server sent a short Rversion")
+	}
+	r.messageSize = min(r.messageSize, binary.LittleEndian.Uint32(res))
+	if version := p9ReadString(res[4:]); version != "9P2000" || r.messageSize <= p9ReadHeaderSize {
+		return fmt.Errorf("9P server doesn't support 9P2000, it offered %q", version)
+	}
+
+	if _, err = r.rpc(p9Tattach, p9Message{}.uint32(p9RootFid).uint32(p9NoFid).string("none").string("")); err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, name := range strings.Split(r.path, "/") {
+		if name != "" && name != "." {
+			names = append(names, name)
+		}
+	}
+	if len(names) > p9MaxWalkNames {
+		return fmt.Errorf("9P path %s is more than %d names deep", r.path, p9MaxWalkNames)
+	}
+	msg := p9Message{}.uint32(p9RootFid).uint32(p9FileFid).uint16(uint16(len(names)))
+	for _, name := range names {
+		msg = msg.string(name)
+	}
+	res, err = r.rpc(p9Twalk, msg)
+	if err != nil {
+		return err
+	}
+	if len(res) < 2 || int(binary.LittleEndian.Uint16(res)) != len(names) {
+		return fmt.Errorf("9P server has no file at %s", r.path)
+	}
+
+	// Read only
+	res, err = r.rpc(p9Topen, p9Message{}.uint32(p9FileFid).uint8(0))
+	if err != nil {
+		return err
+	}
+	if len(res) < 13+4 {
+		return errors.New("9P server sent a short Ropen")
+	}
+	r.ioUnit = binary.LittleEndian.Uint32(res[13:])
+	return nil
+}
+
+// Send a T-message and return the body of its R-message, with an error for Rerror.
+func (r *plan9ChunkReader) rpc(msgType uint8, body p9Message) ([]byte, error) {
+	r.conn.SetDeadline(time.Now().Add(r.timeout))
+
+	// Requests are sent one at a time, so every one can use the same tag, except Tversion which has to use NOTAG.
+	tag := uint16(1)
+	if msgType == p9Tversion {
+		tag = p9NoTag
+	}
+	msg := p9Message{}.uint32(uint32(4 + 1 + 2 + len(body))).uint8(msgType).uint16(tag)
+	if _, err := r.conn.Write(append(msg, body...)); err != nil {
+		return nil, err
+	}
+
+	var header [7]byte
+	if _, err := io.ReadFull(r.conn, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[:])
+	if size < 7 || size > r.messageSize {
+		return nil, errors.New("9P server sent a message of invalid size")
+	}
+	res := make([]byte, size-7)
+	if _, err := io.ReadFull(r.conn, res); err != nil {
+		return nil, err
+	}
+
+	switch header[4] {
+	case p9Rerror:
+		message := p9ReadString(res)
+		return nil, fmt.Errorf("9P server error: %s", message)
+	case msgType + 1:
+		return res, nil
+	}
+	return nil, fmt.Errorf("9P server sent message type %d in response to %d", header[4], msgType)
+}
+
+// 9P messages are little endian, with strings prefixed by their 2 byte length.
+type p9Message []byte
+
+func (m p9Message) uint8(v uint8) p9Message { return append(m, v) }
+
+func (m p9Message) uint16(v uint16) p9Message { return binary.LittleEndian.AppendUint16(m, v) }
+
+func (m p9Message) uint32(v uint32) p9Message { return binary.LittleEndian.AppendUint32(m, v) }
+
+func (m p9Message) uint64(v uint64) p9Message { return binary.LittleEndian.AppendUint64(m, v) }
+
+func (m p9Message) string(s string) p9Message { return append(m.uint16(uint16(len(s))), s...) }
+
+// Empty for malformed strings.
+func p9ReadString(b []byte) string {
+	if len(b) < 2 || len(b) < 2+int(binary.LittleEndian.Uint16(b)) {
+		return ""
+	}
+	return string(b[2 : 2+binary.LittleEndian.Uint16(b)])
+}
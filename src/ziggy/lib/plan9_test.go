@@ -0,0 +1,201 @@
+package lib
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"uosc/bins/src/ziggy/lib/testutil"
+)
+
+type plan9TestServer struct {
+	// File contents by path, with directories implied by the paths.
+	files       map[string][]byte
+	version     string
+	messageSize uint32
+	ioUnit      uint32
+}
+
+// Serve 9P2000 on `network`, and return the address to pass to `NewPlan9ChunkReader()`.
+func startPlan9Server(tb testing.TB, network string, server plan9TestServer) string {
+	tb.Helper()
+	address := "127.0.0.1:0"
+	if network == "unix" {
+		address = filepath.Join(tb.TempDir(), "9p.sock")
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	if network == "unix" {
+		return "unix:" + address
+	}
+	return listener.Addr().String()
+}
+
+func (s plan9TestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	fids := map[uint32]string{}
+	qid := make([]byte, 13)
+
+	for {
+		var header [7]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(header[:])-7)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		msgType, tag := header[4], binary.LittleEndian.Uint16(header[5:])
+		respond := func(msgType uint8, res p9Message) {
+			msg := p9Message{}.uint32(uint32(7 + len(res))).uint8(msgType).uint16(tag)
+			conn.Write(append(msg, res...))
+		}
+		fail := func(message string) {
+			respond(p9Rerror, p9Message{}.string(message))
+		}
+		fid := binary.LittleEndian.Uint32(body)
+
+		switch msgType {
+		case p9Tversion:
+			if tag != p9NoTag {
+				fail("Tversion without NOTAG")
+				continue
+			}
+			respond(msgType+1, p9Message{}.uint32(min(fid, s.messageSize)).string(s.version))
+		case p9Tattach:
+			fids[fid] = ""
+			respond(msgType+1, qid)
+		case p9Twalk:
+			path, ok := fids[fid]
+			if !ok {
+				fail("unknown fid")
+				continue
+			}
+			newFid, count := binary.LittleEndian.Uint32(body[4:]), int(binary.LittleEndian.Uint16(body[8:]))
+			names := body[10:]
+			res := p9Message{}
+			walked := 0
+			for ; walked < count; walked++ {
+				name := p9ReadString(names)
+				names = names[2+len(name):]
+				next := strings.TrimPrefix(path+"/"+name, "/")
+				if !s.exists(next) {
+					break
+				}
+				path = next
+				res = append(res, qid...)
+			}
+			if walked == 0 && count > 0 {
+				fail("file not found")
+				continue
+			}
+			if walked == count {
+				fids[newFid] = path
+			}
+			respond(msgType+1, append(p9Message{}.uint16(uint16(walked)), res...))
+		case p9Topen:
+			if _, ok := s.files[fids[fid]]; !ok {
+				fail("can't open a directory")
+				continue
+			}
+			respond(msgType+1, append(append(p9Message{}, qid...), p9Message{}.uint32(s.ioUnit)...))
+		case p9Tstat:
+			data := s.files[fids[fid]]
+			stat := p9Message{}.uint16(0).uint32(0)
+			stat = append(stat, qid...)
+			stat = stat.uint32(0o644).uint32(0).uint32(0).uint64(uint64(len(data))).string("movie.mkv").string("").string("").string("")
+			stat = append(p9Message{}.uint16(uint16(len(stat))), stat...)
+			respond(msgType+1, append(p9Message{}.uint16(uint16(len(stat))), stat...))
+		case p9Tread:
+			data := s.files[fids[fid]]
+			offset, count := int64(binary.LittleEndian.Uint64(body[4:])), int64(binary.LittleEndian.Uint32(body[12:]))
+			if int64(p9ReadHeaderSize)+count > int64(s.messageSize) || (s.ioUnit > 0 && count > int64(s.ioUnit)) {
+				fail("read is too big")
+				continue
+			}
+			chunk := data[min(offset, int64(len(data))):min(offset+count, int64(len(data)))]
+			respond(msgType+1, append(p9Message{}.uint32(uint32(len(chunk))), chunk...))
+		default:
+			fail("unsupported")
+		}
+	}
+}
+
+func (s plan9TestServer) exists(path string) bool {
+	for file := range s.files {
+		if file == path || strings.HasPrefix(file, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPlan9ChunkReader(t *testing.T) {
+	data := readSyntheticFile(t, 200000)
+	// As deep as a single Twalk can take, and one deeper
+	deepPath := strings.Repeat("dir/", p9MaxWalkNames-1) + "movie.mkv"
+	tooDeepPath := "dir/" + deepPath
+
+	tests := []struct {
+		name    string
+		network string
+		path    string
+		server  plan9TestServer
+		wantErr bool
+	}{
+		{name: "tcp", network: "tcp", path: "/media/movie.mkv"},
+		{name: "relative path", network: "tcp", path: "media/./movie.mkv"},
+		{name: "unix socket", network: "unix", path: "/media/movie.mkv"},
+		{name: "deep path", network: "tcp", path: deepPath},
+		{name: "small messages", network: "tcp", path: "/media/movie.mkv", server: plan9TestServer{messageSize: 4096}},
+		{name: "io unit", network: "tcp", path: "/media/movie.mkv", server: plan9TestServer{ioUnit: 1000}},
+		{name: "missing file", network: "tcp", path: "/media/other.mkv", wantErr: true},
+		{name: "too deep", network: "tcp", path: tooDeepPath, wantErr: true},
+		{name: "directory", network: "tcp", path: "/media", wantErr: true},
+		{name: "other version", network: "tcp", path: "/media/movie.mkv", server: plan9TestServer{version: "9P2000.L"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.network == "unix" && runtime.GOOS == "windows" {
+				t.Skip("unix sockets aren't available on all windows versions")
+			}
+			server := tt.server
+			server.files = map[string][]byte{"media/movie.mkv": data, deepPath: data, tooDeepPath: data}
+			if server.version == "" {
+				server.version = "9P2000"
+			}
+			if server.messageSize == 0 {
+				server.messageSize = 1 << 20
+			}
+
+			reader := NewPlan9ChunkReader(startPlan9Server(t, tt.network, server), tt.path)
+			defer reader.(io.Closer).Close()
+			if tt.wantErr {
+				if size, err := reader.Size(); err == nil {
+					t.Errorf("Size() succeeded with %d", size)
+				}
+				return
+			}
+			if err := testutil.ValidateChunkReader(reader, data); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
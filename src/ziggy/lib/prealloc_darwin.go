@@ -0,0 +1,26 @@
+package lib
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func preallocate(file *os.File, size int64) error {
+	fi, err := file.Stat()
+	if err != nil || fi.Size() >= size {
+		return err
+	}
+	// Space is allocated past the current end of file, contiguously if possible.
+	store := &unix.Fstore_t{Flags: unix.F_ALLOCATECONTIG, Posmode: unix.F_PEOFPOSMODE, Length: size - fi.Size()}
+	err = unix.FcntlFstore(file.Fd(), unix.F_PREALLOCATE, store)
+	if err != nil {
+		store.Flags = unix.F_ALLOCATEALL
+		err = unix.FcntlFstore(file.Fd(), unix.F_PREALLOCATE, store)
+	}
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EINVAL) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package lib
+
+import "os"
+
+func preallocate(file *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,25 @@
+package lib
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func preallocate(file *os.File, size int64) error {
+	// Allocations below the end of file truncate it on NTFS.
+	fi, err := file.Stat()
+	if err != nil || fi.Size() >= size {
+		return err
+	}
+	// FILE_ALLOCATION_INFO reserves clusters without changing the file size, as `fallocate(2)` does with
+	// `FALLOC_FL_KEEP_SIZE`.
+	info := struct{ AllocationSize int64 }{size}
+	err = windows.SetFileInformationByHandle(windows.Handle(file.Fd()), windows.FileAllocationInfo, (*byte)(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+	if errors.Is(err, windows.ERROR_INVALID_FUNCTION) || errors.Is(err, windows.ERROR_NOT_SUPPORTED) {
+		return nil
+	}
+	return err
+}
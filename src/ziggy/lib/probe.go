@@ -0,0 +1,278 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// How much of the file header is read when looking for container metadata.
+const probeHeaderSize = 512 * 1024
+
+// Container metadata of a media file, next to its hash.
+// Metadata fields are left empty when they're not found in the file's header, which is the case for MP4 files
+// with the `moov` box stored at the end of the file.
+type FileProbe struct {
+	Hash     string `json:"hash"`
+	FileSize int64  `json:"file_size"`
+	// `matroska`, `webm`, or `mp4`.
+	Container string `json:"container"`
+	// Codec identifiers as stored in the container, such as `V_MPEG4/ISO/AVC` in matroska, or `avc1` in mp4.
+	VideoCodec string        `json:"video_codec"`
+	AudioCodec string        `json:"audio_codec"`
+	Width      int           `json:"width"`
+	Height     int           `json:"height"`
+	Duration   time.Duration `json:"duration"`
+	// Average bits per second, estimated from file size and duration.
+	Bitrate int64 `json:"bitrate"`
+}
+
+// Hash a file and read its container metadata from the same header read, so callers don't need to run `ffprobe`.
+func ProbeFile(ctx context.Context, filePath string) (*FileProbe, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.New("couldn't open file for hashing")
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, errors.New("couldn't stat file for hashing")
+	}
+
+	fileSize := fi.Size()
+	if fileSize < OSDBChunkSize {
+		return nil, errors.New("file is too small to generate a valid hash")
+	}
+
+	header := make([]byte, min(fileSize, probeHeaderSize))
+	if err := readChunk(file, 0, header); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	chunks := make([]byte, OSDBChunkSize*2)
+	copy(chunks, header[:OSDBChunkSize])
+	if err := readChunk(file, fileSize-OSDBChunkSize, chunks[OSDBChunkSize:]); err != nil {
+		return nil, err
+	}
+
+	probe := &FileProbe{Hash: osdbHash(fileSize, chunks), FileSize: fileSize}
+
+	// Metadata is best effort, the header might end in the middle of an element we're interested in.
+	reader := bytes.NewReader(header)
+	if el, err := readEBMLElement(reader, 0); err == nil && el.id == ebmlIDHeader {
+		probeMKV(reader, int64(len(header)), probe)
+	} else if string(header[4:8]) == "ftyp" {
+		probeMP4(reader, int64(len(header)), probe)
+	}
+
+	if probe.Duration > 0 {
+		probe.Bitrate = int64(float64(fileSize*8) / probe.Duration.Seconds())
+	}
+
+	return probe, nil
+}
+
+const (
+	mkvTrackTypeVideo = 1
+	mkvTrackTypeAudio = 2
+)
+
+func probeMKV(r io.ReaderAt, size int64, probe *FileProbe) error {
+	probe.Container = "matroska"
+	err := forEachEBMLChild(r, 0, size, func(el ebmlElement) error {
+		switch el.id {
+		case ebmlIDHeader:
+			return forEachEBMLChild(r, el.dataOffset, el.end(size), func(el ebmlElement) error {
+				if el.id == ebmlIDDocType {
+					docType, err := readEBMLString(r, el)
+					if docType != "" {
+						probe.Container = docType
+					}
+					return err
+				}
+				return nil
+			})
+		case ebmlIDSegment:
+			return forEachEBMLChild(r, el.dataOffset, min(el.end(size), size), func(el ebmlElement) error {
+				switch el.id {
+				case ebmlIDInfo:
+					timecodeScale, duration, err := readMKVInfo(r, el)
+					probe.Duration = time.Duration(duration * float64(timecodeScale))
+					return err
+				case ebmlIDTracks:
+					return forEachEBMLChild(r, el.dataOffset, el.end(size), func(el ebmlElement) error {
+						if el.id == ebmlIDTrackEntry {
+							return probeMKVTrack(r, el, probe)
+						}
+						return nil
+					})
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return err
+}
+
+func probeMKVTrack(r io.ReaderAt, entry ebmlElement, probe *FileProbe) error {
+	trackType := uint64(0)
+	codecID := ""
+	width, height := uint64(0), uint64(0)
+	err := forEachEBMLChild(r, entry.dataOffset, entry.end(0), func(el ebmlElement) (err error) {
+		switch el.id {
+		case ebmlIDTrackType:
+			trackType, err = readEBMLUint(r, el)
+		case ebmlIDCodecID:
+			codecID, err = readEBMLString(r, el)
+		case ebmlIDVideo:
+			err = forEachEBMLChild(r, el.dataOffset, el.end(0), func(el ebmlElement) (err error) {
+				switch el.id {
+				case ebmlIDPixelWidth:
+					width, err = readEBMLUint(r, el)
+				case ebmlIDPixelHeight:
+					height, err = readEBMLUint(r, el)
+				}
+				return err
+			})
+		}
+		return err
+	})
+
+	// First track of each type wins.
+	switch {
+	case trackType == mkvTrackTypeVideo && probe.VideoCodec == "":
+		probe.VideoCodec, probe.Width, probe.Height = codecID, int(width), int(height)
+	case trackType == mkvTrackTypeAudio && probe.AudioCodec == "":
+		probe.AudioCodec = codecID
+	}
+	return err
+}
+
+type mp4Box struct {
+	kind       string
+	dataOffset int64
+	end        int64
+}
+
+// Call `fn` for each box in the `[start, end)` range.
+func forEachMP4Box(r io.ReaderAt, start, end int64, fn func(box mp4Box) error) error {
+	for offset := start; offset+8 <= end; {
+		var header [16]byte
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return err
+		}
+		box := mp4Box{kind: string(header[4:8]), dataOffset: offset + 8}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		switch size {
+		case 0:
+			// Box extends to the end of its parent
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			box.dataOffset += 8
+		}
+		if size < box.dataOffset-offset {
+			return errors.New("invalid mp4 box size")
+		}
+		box.end = offset + size
+		if err := fn(box); err != nil {
+			return err
+		}
+		offset = box.end
+	}
+	return nil
+}
+
+func probeMP4(r io.ReaderAt, size int64, probe *FileProbe) error {
+	probe.Container = "mp4"
+	return forEachMP4Box(r, 0, size, func(box mp4Box) error {
+		if box.kind != "moov" {
+			return nil
+		}
+		return forEachMP4Box(r, box.dataOffset, min(box.end, size), func(box mp4Box) error {
+			switch box.kind {
+			case "mvhd":
+				return probeMP4Duration(r, box, probe)
+			case "trak":
+				return probeMP4Track(r, box, probe)
+			}
+			return nil
+		})
+	})
+}
+
+func probeMP4Duration(r io.ReaderAt, mvhd mp4Box, probe *FileProbe) error {
+	var data [32]byte
+	if _, err := r.ReadAt(data[:], mvhd.dataOffset); err != nil {
+		return err
+	}
+	var timescale, duration uint64
+	if data[0] == 1 {
+		timescale = uint64(binary.BigEndian.Uint32(data[20:24]))
+		duration = binary.BigEndian.Uint64(data[24:32])
+	} else {
+		timescale = uint64(binary.BigEndian.Uint32(data[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(data[16:20]))
+	}
+	if timescale > 0 {
+		probe.Duration = time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+	}
+	return nil
+}
+
+func probeMP4Track(r io.ReaderAt, trak mp4Box, probe *FileProbe) error {
+	handler := ""
+	codec := ""
+	width, height := 0, 0
+
+	var visit func(box mp4Box) error
+	visit = func(box mp4Box) error {
+		switch box.kind {
+		case "mdia", "minf", "stbl":
+			return forEachMP4Box(r, box.dataOffset, box.end, visit)
+		case "tkhd":
+			// Width and height are 16.16 fixed point numbers at the end of the box.
+			var data [8]byte
+			if _, err := r.ReadAt(data[:], box.end-8); err != nil {
+				return err
+			}
+			width = int(binary.BigEndian.Uint32(data[0:4]) >> 16)
+			height = int(binary.BigEndian.Uint32(data[4:8]) >> 16)
+		case "hdlr":
+			var data [12]byte
+			if _, err := r.ReadAt(data[:], box.dataOffset); err != nil {
+				return err
+			}
+			handler = string(data[8:12])
+		case "stsd":
+			// Version & flags, entry count, and first sample entry's size precede its format.
+			var data [16]byte
+			if _, err := r.ReadAt(data[:], box.dataOffset); err != nil {
+				return err
+			}
+			codec = string(data[12:16])
+		}
+		return nil
+	}
+	err := forEachMP4Box(r, trak.dataOffset, trak.end, visit)
+
+	switch {
+	case handler == "vide" && probe.VideoCodec == "":
+		probe.VideoCodec, probe.Width, probe.Height = codec, width, height
+	case handler == "soun" && probe.AudioCodec == "":
+		probe.AudioCodec = codec
+	}
+	return err
+}
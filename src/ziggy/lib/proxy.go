@@ -0,0 +1,185 @@
+package lib
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Number of URLs `StartHashProxy()` remembers hashes of, and for how long, as remote files can change.
+	hashProxyCacheSize = 1024
+	hashProxyCacheTTL  = time.Hour
+)
+
+type hashProxyResult struct {
+	Hash      string `json:"hash"`
+	Size      int64  `json:"size"`
+	FromCache bool   `json:"from_cache"`
+}
+
+type hashProxyEntry struct {
+	url    string
+	result hashProxyResult
+	added  time.Time
+}
+
+// Least recently used cache of hashes by URL.
+type hashProxyCache struct {
+	mutex   sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newHashProxyCache(size int, ttl time.Duration) *hashProxyCache {
+	return &hashProxyCache{size: size, ttl: ttl, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *hashProxyCache) get(url string) (hashProxyResult, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[url]
+	if !ok {
+		return hashProxyResult{}, false
+	}
+	entry := element.Value.(hashProxyEntry)
+	if time.Since(entry.added) > c.ttl {
+		c.order.Remove(element)
+		delete(c.entries, url)
+		return hashProxyResult{}, false
+	}
+	c.order.MoveToFront(element)
+	entry.result.FromCache = true
+	return entry.result, true
+}
+
+func (c *hashProxyCache) add(url string, result hashProxyResult) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry := hashProxyEntry{url, result, time.Now()}
+	if element, ok := c.entries[url]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[url] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(hashProxyEntry).url)
+	}
+}
+
+// Serve OSDB hashes of remote files on `addr`, for clients that can't use this package directly.
+// `GET /hash?url=<encoded-url>` responds with `{"hash":"…","size":…,"from_cache":…}`, or an `ErrorData` on failure.
+// Only `http(s)://` URLs on public addresses are hashed, so the proxy never exposes local files or services on the
+// network it runs in, and hashes are cached for an hour. Blocks until the server fails.
+func StartHashProxy(addr string) error {
+	cache := newHashProxyCache(hashProxyCacheSize, hashProxyCacheTTL)
+	// Checked on every connection, redirects included, after host names are resolved.
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second, Control: rejectNonPublicAddress}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hash", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON := func(status int, value any) {
+			json, err := JSONMarshal(value)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write(json)
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSON(http.StatusMethodNotAllowed, NewErrorData(errors.New("only GET requests are supported")))
+			return
+		}
+		url := r.URL.Query().Get("url")
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			writeJSON(http.StatusBadRequest, NewErrorData(errors.New("url parameter has to be an http(s) URL")))
+			return
+		}
+
+		if result, ok := cache.get(url); ok {
+			writeJSON(http.StatusOK, result)
+			return
+		}
+		hash, _, fileSize, err := OSDBHashFileURL(url, WithDialer(dialer.DialContext))
+		if err != nil {
+			writeJSON(http.StatusBadGateway, NewErrorData(err))
+			return
+		}
+		result := hashProxyResult{Hash: hash, Size: fileSize}
+		cache.add(url, result)
+		writeJSON(http.StatusOK, result)
+	})
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		// Long enough for a hash that's slow to read
+		WriteTimeout: time.Minute,
+		IdleTimeout:  time.Minute,
+	}
+	return server.ListenAndServe()
+}
+
+// Serve Kubernetes style probes for a long-running server such as `StartHashProxy()` on `addr`. `/livez` responds
+// with 200 while the process is running, and `/readyz` with 200 or 503 depending on `ready`, which can check that
+// the server accepts connections by dialing its address. Close the returned server to stop serving. If `addr` can't
+// be listened on, closing returns that error.
+func StartHTTPProbes(addr string, ready func() bool) io.Closer {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return closerFunc(func() error { return err })
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	return server
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+func rejectNonPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("refusing to connect to non-public address %s", host)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStartHTTPProbes(t *testing.T) {
+	// Probes don't report the address they listen on, so find a free one first.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	var ready atomic.Bool
+	probes := StartHTTPProbes(addr, ready.Load)
+	defer probes.Close()
+
+	status := func(path string) int {
+		t.Helper()
+		res, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if code := status("/livez"); code != http.StatusOK {
+		t.Errorf("/livez is %d, expected 200", code)
+	}
+	if code := status("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz is %d before ready, expected 503", code)
+	}
+	ready.Store(true)
+	if code := status("/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz is %d once ready, expected 200", code)
+	}
+
+	if err := StartHTTPProbes(addr, ready.Load).Close(); err == nil {
+		t.Error("listening on an address in use succeeded")
+	}
+}
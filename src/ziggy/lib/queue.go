@@ -0,0 +1,220 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrQueueClosed = errors.New("hash queue is closed")
+
+type hashJob struct {
+	path   string
+	result chan HashResult
+}
+
+// HashQueue hashes files in the background, for callers that know about files long before they need their hashes.
+type HashQueue struct {
+	opts []Option
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	jobs     []hashJob
+	inFlight int
+	closed   bool
+	// Files queued or being hashed, and a channel closed once there are none, for `Flush()`.
+	pending int
+	idle    chan struct{}
+}
+
+// Start a queue with `workers` background hashers, `opts` are passed to every `OSDBHashFileResult()` call.
+func NewHashQueue(workers int, opts ...Option) *HashQueue {
+	q := &HashQueue{opts: opts}
+	q.cond = sync.NewCond(&q.mutex)
+	for i := 0; i < max(workers, 1); i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Queue `path` for hashing. Returned channel receives exactly one result, with `Err` set when hashing failed.
+func (q *HashQueue) Enqueue(path string) <-chan HashResult {
+	result := make(chan HashResult, 1)
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		result <- HashResult{Err: ErrQueueClosed}
+		return result
+	}
+	if q.pending == 0 {
+		q.idle = make(chan struct{})
+	}
+	q.pending++
+	q.jobs = append(q.jobs, hashJob{path, result})
+	q.cond.Signal()
+	return result
+}
+
+// Wait for all queued files to be hashed, or for `ctx` to be done.
+func (q *HashQueue) Flush(ctx context.Context) error {
+	q.mutex.Lock()
+	if q.pending == 0 {
+		q.mutex.Unlock()
+		return nil
+	}
+	idle := q.idle
+	q.mutex.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stop the workers once already queued files are hashed, later `Enqueue()` calls fail with `ErrQueueClosed`.
+func (q *HashQueue) Close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func (q *HashQueue) work() {
+	for {
+		q.mutex.Lock()
+		for len(q.jobs) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.jobs) == 0 {
+			q.mutex.Unlock()
+			return
+		}
+		job := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		q.inFlight++
+		q.mutex.Unlock()
+
+		result, err := OSDBHashFileResult(job.path, q.opts...)
+		result.Err = err
+		job.result <- result
+
+		q.mutex.Lock()
+		q.inFlight--
+		q.pending--
+		if q.pending == 0 {
+			close(q.idle)
+		}
+		q.mutex.Unlock()
+	}
+}
+
+// Number of files waiting for a worker, and being hashed right now.
+func (q *HashQueue) stats() (queued, inFlight int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.jobs), q.inFlight
+}
+
+type PoolStats struct {
+	DiskQueued      int `json:"disk_queued"`
+	DiskInFlight    int `json:"disk_in_flight"`
+	NetworkQueued   int `json:"network_queued"`
+	NetworkInFlight int `json:"network_in_flight"`
+}
+
+// HashPool hashes local files and URLs with separate workers, so slow servers don't hold up disk reads,
+// and a busy disk doesn't hold up remote reads.
+type HashPool struct {
+	disk    *HashQueue
+	network *HashQueue
+}
+
+// Start a pool with `diskWorkers` hashers for local files and `networkWorkers` for URLs,
+// `opts` are passed to every `OSDBHashFileResult()` call.
+func NewHashPool(diskWorkers, networkWorkers int, opts ...Option) *HashPool {
+	return &HashPool{
+		disk:    NewHashQueue(diskWorkers, opts...),
+		network: NewHashQueue(networkWorkers, opts...),
+	}
+}
+
+// Queue `path` for hashing by the disk or network workers, anything in `scheme://` format is a URL.
+// Returned channel receives exactly one result, with `Err` set when hashing failed.
+func (p *HashPool) Enqueue(path string) <-chan HashResult {
+	if strings.Contains(path, "://") {
+		return p.network.Enqueue(path)
+	}
+	return p.disk.Enqueue(path)
+}
+
+// Wait for all queued files and URLs to be hashed, or for `ctx` to be done.
+func (p *HashPool) Flush(ctx context.Context) error {
+	if err := p.disk.Flush(ctx); err != nil {
+		return err
+	}
+	return p.network.Flush(ctx)
+}
+
+// Stop the workers once already queued files are hashed, later `Enqueue()` calls fail with `ErrQueueClosed`.
+func (p *HashPool) Close() {
+	p.disk.Close()
+	p.network.Close()
+}
+
+// Current queue depths and in-flight counts of both worker pools.
+func (p *HashPool) Stats() PoolStats {
+	var stats PoolStats
+	stats.DiskQueued, stats.DiskInFlight = p.disk.stats()
+	stats.NetworkQueued, stats.NetworkInFlight = p.network.stats()
+	return stats
+}
+
+// Start hashing a file right away, so it's likely done by the time a player is ready to search for subtitles.
+// Channel receives the result if it's ready within `budget`. Otherwise it first receives a result with `Partial` set
+// as soon as `budget` expires, and the full result once hashing finishes in the background. Channel is closed after
+// the full result, or right away when the returned function is called, which also stops hashing.
+func OSDBHashFileSpeculative(filePath string, budget time.Duration) (<-chan HashResult, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan HashResult, 2)
+	done := make(chan HashResult, 1)
+
+	go func() {
+		result, err := OSDBHashFileResult(filePath, WithContext(ctx))
+		result.Err = err
+		done <- result
+	}()
+
+	go func() {
+		defer close(results)
+		timer := time.NewTimer(budget)
+		defer timer.Stop()
+
+		select {
+		case result := <-done:
+			results <- result
+			return
+		case <-timer.C:
+			partial := HashResult{Partial: true, Duration: budget}
+			if fi, err := os.Stat(filePath); err == nil {
+				partial.FileSize = fi.Size()
+			}
+			results <- partial
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case result := <-done:
+			results <- result
+		case <-ctx.Done():
+		}
+	}()
+
+	return results, cancel
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"uosc/bins/src/ziggy/lib/testutil"
+)
+
+func TestHashQueue(t *testing.T) {
+	sizes := []int64{OSDBChunkSize, 100000, 1 << 20, 100}
+	queue := NewHashQueue(2)
+	defer queue.Close()
+
+	if err := queue.Flush(context.Background()); err != nil {
+		t.Fatalf("flushing an empty queue failed: %v", err)
+	}
+
+	results := make([]<-chan HashResult, len(sizes))
+	for i, size := range sizes {
+		results[i] = queue.Enqueue(testutil.CreateSyntheticVideoFile(t, size))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := queue.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, size := range sizes {
+		select {
+		case result := <-results[i]:
+			expected := testutil.KnownHash(size)
+			if expected == "" {
+				if result.Err == nil {
+					t.Errorf("hashing %d bytes succeeded with %s", size, result.Hash)
+				}
+				continue
+			}
+			if result.Err != nil {
+				t.Errorf("hashing %d bytes failed: %v", size, result.Err)
+			} else if result.Hash != expected || result.FileSize != size {
+				t.Errorf("hash of %d bytes is %s of %d bytes, expected %s", size, result.Hash, result.FileSize, expected)
+			}
+		default:
+			t.Errorf("no result for %d bytes after flushing", size)
+		}
+	}
+
+	// Queue is reusable after it's idle
+	result := queue.Enqueue(testutil.CreateSyntheticVideoFile(t, OSDBChunkSize))
+	if err := queue.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if (<-result).Err != nil {
+		t.Error("hashing after a flush failed")
+	}
+}
+
+func TestHashQueueFlushContext(t *testing.T) {
+	// Server holds the hash up until it's released.
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		http.Error(w, "released", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	queue := NewHashQueue(1)
+	defer queue.Close()
+	result := queue.Enqueue(server.URL + "/movie.mkv")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := queue.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error is %v, expected context.DeadlineExceeded", err)
+	}
+
+	close(release)
+	if err := queue.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if (<-result).Err == nil {
+		t.Error("hashing an unavailable URL succeeded")
+	}
+}
+
+func TestHashQueueClosed(t *testing.T) {
+	queue := NewHashQueue(1)
+	queue.Close()
+	if result := <-queue.Enqueue("movie.mkv"); !errors.Is(result.Err, ErrQueueClosed) {
+		t.Errorf("error is %v, expected ErrQueueClosed", result.Err)
+	}
+	if err := queue.Flush(context.Background()); err != nil {
+		t.Errorf("flushing a closed queue failed: %v", err)
+	}
+}
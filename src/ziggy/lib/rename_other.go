@@ -0,0 +1,7 @@
+//go:build !linux && !windows
+
+package lib
+
+func renameNoReplace(oldPath, newPath string) error {
+	return linkRename(oldPath, newPath)
+}
@@ -0,0 +1,23 @@
+package lib
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+func renameNoReplace(oldPath, newPath string) error {
+	from, err := windows.UTF16PtrFromString(oldPath)
+	if err != nil {
+		return err
+	}
+	to, err := windows.UTF16PtrFromString(newPath)
+	if err != nil {
+		return err
+	}
+	// Without MOVEFILE_REPLACE_EXISTING, moves fail when `newPath` exists.
+	if err := windows.MoveFileEx(from, to, 0); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+	}
+	return nil
+}
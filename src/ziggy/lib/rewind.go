@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+var ErrChunkTooLargeToBuffer = errors.New("chunk is too large to buffer for retrying its read")
+
+const (
+	// Bytes of a chunk kept to check retries against.
+	rewindBufferLimit = 2 * OSDBChunkSize
+	// Default extra requests for a chunk after its response fails part way, see `WithMaxRetries()`.
+	rewindRetries = 2
+)
+
+// Reads the `length` bytes of a remote file at `offset`, sending the request again when the response fails part
+// way. The whole range is fetched again rather than resumed, and its start checked against the bytes already read,
+// so a file replaced in between isn't hashed half from each version. Only up to `limit` bytes are kept for that,
+// so longer chunks can't be retried once more than that is read, and fail with `ErrChunkTooLargeToBuffer`.
+type rewindableReader struct {
+	ctx            context.Context
+	client         *http.Client
+	url            string
+	offset, length int64
+	limit          int
+	maxRetries     int
+
+	body     io.ReadCloser
+	read     int64
+	buffered []byte
+	retries  int
+}
+
+func newRewindableReader(ctx context.Context, client *http.Client, url string, offset, length int64, maxRetries int) *rewindableReader {
+	return &rewindableReader{
+		ctx:        ctx,
+		client:     client,
+		url:        url,
+		offset:     offset,
+		length:     length,
+		limit:      rewindBufferLimit,
+		maxRetries: maxRetries,
+	}
+}
+
+func (r *rewindableReader) Read(p []byte) (int, error) {
+	for {
+		if r.body == nil {
+			if err := r.open(); err != nil {
+				return 0, err
+			}
+		}
+
+		n, err := r.body.Read(p)
+		r.read += int64(n)
+		if room := r.limit - len(r.buffered); room > 0 {
+			r.buffered = append(r.buffered, p[:min(n, room)]...)
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+
+		r.body.Close()
+		r.body = nil
+		if r.retries >= r.maxRetries {
+			return n, err
+		}
+		r.retries++
+		if r.read > int64(len(r.buffered)) {
+			return n, fmt.Errorf("%w: %w", ErrChunkTooLargeToBuffer, err)
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (r *rewindableReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
+
+// Send the request, and on retries skip past the bytes already read, checking they're the same.
+func (r *rewindableReader) open() error {
+	req, err := http.NewRequestWithContext(r.ctx, "GET", r.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Range", "bytes="+strconv.FormatInt(r.offset, 10)+"-"+strconv.FormatInt(r.offset+r.length-1, 10))
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if r.read > 0 {
+		prefix := make([]byte, r.read)
+		if _, err := io.ReadFull(res.Body, prefix); err != nil {
+			res.Body.Close()
+			return err
+		}
+		if !bytes.Equal(prefix, r.buffered) {
+			res.Body.Close()
+			return errors.New("file changed while retrying a chunk read")
+		}
+	}
+	r.body = res.Body
+	return nil
+}
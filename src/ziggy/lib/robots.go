@@ -0,0 +1,178 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+)
+
+var ErrDisallowed = errors.New("URL is disallowed by robots.txt")
+
+// Product token of the default user agent of `net/http`, which is what remote reads are sent with.
+const robotsUserAgent = "go-http-client"
+
+type robotsRule struct {
+	allow bool
+	path  string
+}
+
+var (
+	robotsMutex sync.Mutex
+	// Rules that apply to us, keyed by `scheme://host`.
+	robotsCache = map[string][]robotsRule{}
+)
+
+// Error with `ErrDisallowed` when robots.txt of the URL's host doesn't allow us to fetch it.
+func checkRobots(ctx context.Context, client *http.Client, url string) error {
+	parsed, err := neturl.Parse(url)
+	if err != nil {
+		return err
+	}
+	rules, err := robotsRules(ctx, client, parsed.Scheme+"://"+parsed.Host)
+	if err != nil {
+		return err
+	}
+
+	// Longest matching rule wins, and allow wins ties.
+	target := parsed.EscapedPath()
+	if parsed.RawQuery != "" {
+		target += "?" + parsed.RawQuery
+	}
+	allowed, matched := true, -1
+	for _, rule := range rules {
+		if len(rule.path) < matched || !matchRobotsPath(rule.path, target) {
+			continue
+		}
+		if len(rule.path) > matched || rule.allow {
+			allowed, matched = rule.allow, len(rule.path)
+		}
+	}
+	if !allowed {
+		return ErrDisallowed
+	}
+	return nil
+}
+
+// Error with `ErrDisallowed` when the `X-Robots-Tag` header asks robots to stay away.
+func checkRobotsTag(header http.Header) error {
+	for _, value := range header.Values("X-Robots-Tag") {
+		for _, directive := range strings.Split(strings.ToLower(value), ",") {
+			if directive = strings.TrimSpace(directive); directive == "noindex" || directive == "none" {
+				return ErrDisallowed
+			}
+		}
+	}
+	return nil
+}
+
+func robotsRules(ctx context.Context, client *http.Client, origin string) ([]robotsRule, error) {
+	robotsMutex.Lock()
+	rules, ok := robotsCache[origin]
+	robotsMutex.Unlock()
+	if ok {
+		return rules, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", origin+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		rules = parseRobots(io.LimitReader(res.Body, 512*1024))
+	case res.StatusCode >= 400 && res.StatusCode < 500:
+		// No robots.txt, everything is allowed
+		rules = []robotsRule{}
+	default:
+		// Server errors mean everything is disallowed until it's reachable again, so don't cache them.
+		return []robotsRule{{allow: false, path: "/"}}, nil
+	}
+
+	robotsMutex.Lock()
+	robotsCache[origin] = rules
+	robotsMutex.Unlock()
+	return rules, nil
+}
+
+// Rules of the group for our user agent, falling back to the `*` group.
+func parseRobots(r io.Reader) []robotsRule {
+	var ours, wildcard []robotsRule
+	foundOurs := false
+	var agents []string
+	inRules := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share a group
+			if inRules {
+				agents, inRules = nil, false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue
+			}
+			rule := robotsRule{allow: key == "allow", path: value}
+			for _, agent := range agents {
+				switch {
+				case agent == robotsUserAgent:
+					ours, foundOurs = append(ours, rule), true
+				case agent == "*":
+					wildcard = append(wildcard, rule)
+				}
+			}
+		}
+	}
+
+	if foundOurs {
+		return ours
+	}
+	if wildcard == nil {
+		return []robotsRule{}
+	}
+	return wildcard
+}
+
+// Match a robots.txt path pattern, where `*` matches any sequence of characters, and a trailing `$` anchors the end.
+func matchRobotsPath(pattern, target string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(target, parts[0]) {
+		return false
+	}
+	target = target[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(target, part)
+		}
+		index := strings.Index(target, part)
+		if index < 0 {
+			return false
+		}
+		target = target[index+len(part):]
+	}
+	return !anchored || target == ""
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+)
+
+var ErrManifestUnsigned = errors.New("manifest has no signature")
+
+var ErrInvalidSignature = errors.New("manifest signature is invalid")
+
+const signatureBlockType = "SIGNATURE"
+
+var signatureBlockStart = []byte("-----BEGIN " + signatureBlockType + "-----")
+
+// Sign a hash manifest with an ECDSA P-256 key, returning `manifest` followed by a `-----BEGIN SIGNATURE-----` PEM
+// block with the ASN.1 signature of its SHA-256. A newline is added to `manifest` first when it doesn't end with one,
+// and is covered by the signature.
+func SignManifest(manifest []byte, privateKey *ecdsa.PrivateKey) ([]byte, error) {
+	if privateKey.Curve != elliptic.P256() {
+		return nil, errors.New("manifest signing key has to be a P-256 one")
+	}
+
+	signed := bytes.Clone(manifest)
+	if len(signed) > 0 && signed[len(signed)-1] != '\n' {
+		signed = append(signed, '\n')
+	}
+	digest := sha256.Sum256(signed)
+	signature, err := ecdsa.SignASN1(rand.Reader, privateKey, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return append(signed, pem.EncodeToMemory(&pem.Block{Type: signatureBlockType, Bytes: signature})...), nil
+}
+
+// Check the signature of a manifest signed with `SignManifest()`. Nothing but whitespace may follow the signature.
+func VerifyManifest(manifest []byte, publicKey *ecdsa.PublicKey) error {
+	start := bytes.LastIndex(manifest, signatureBlockStart)
+	if start < 0 {
+		return ErrManifestUnsigned
+	}
+	block, rest := pem.Decode(manifest[start:])
+	if block == nil || block.Type != signatureBlockType || len(bytes.TrimSpace(rest)) > 0 {
+		return ErrInvalidSignature
+	}
+
+	digest := sha256.Sum256(manifest[:start])
+	if !ecdsa.VerifyASN1(publicKey, digest[:], block.Bytes) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
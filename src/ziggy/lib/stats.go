@@ -0,0 +1,119 @@
+package lib
+
+import (
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Most files and URLs counted separately, reads of any others are counted together under `other`, so the stats of
+// long-running processes stay small however many are hashed.
+const maxReadStatsSources = 256
+
+type readStats struct {
+	Count        int64   `json:"count"`
+	Errors       int64   `json:"errors"`
+	Bytes        int64   `json:"bytes"`
+	MinLatencyMs float64 `json:"min_latency_ms"`
+	MaxLatencyMs float64 `json:"max_latency_ms"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+
+	totalLatency time.Duration
+}
+
+var (
+	readStatsMutex    sync.Mutex
+	readStatsBySource = map[string]*readStats{}
+)
+
+func (s *readStats) record(size int, latency time.Duration, err error) {
+	ms := float64(latency) / float64(time.Millisecond)
+	if s.Count == 0 || ms < s.MinLatencyMs {
+		s.MinLatencyMs = ms
+	}
+	s.MaxLatencyMs = max(s.MaxLatencyMs, ms)
+	s.Count++
+	s.totalLatency += latency
+	s.AvgLatencyMs = float64(s.totalLatency) / float64(time.Millisecond) / float64(s.Count)
+	if err != nil {
+		s.Errors++
+	} else {
+		s.Bytes += int64(size)
+	}
+}
+
+// Wrap a chunk read of `source`, a file path or URL, so it's counted in `DumpReadStats()` with `WithReadStats()`.
+func recordReads(opts Options, source string, read func(offset int64, buf []byte) error) func(offset int64, buf []byte) error {
+	if !opts.ReadStats {
+		return read
+	}
+	source = readStatsSource(source)
+	return func(offset int64, buf []byte) error {
+		start := time.Now()
+		err := read(offset, buf)
+		latency := time.Since(start)
+
+		readStatsMutex.Lock()
+		defer readStatsMutex.Unlock()
+		key := source
+		stats, ok := readStatsBySource[key]
+		if !ok && len(readStatsBySource) >= maxReadStatsSources {
+			key = "other"
+			stats, ok = readStatsBySource[key]
+		}
+		if !ok {
+			stats = &readStats{}
+			readStatsBySource[key] = stats
+		}
+		stats.record(len(buf), latency, err)
+		return err
+	}
+}
+
+// Sources are paths of local files, and URLs without their credentials, which shouldn't end up in debug output.
+func readStatsSource(source string) string {
+	if !strings.Contains(source, "://") {
+		return source
+	}
+	parsed, err := neturl.Parse(source)
+	if err != nil {
+		return "other"
+	}
+	return parsed.Redacted()
+}
+
+// JSON of chunk reads made with `WithReadStats()` since the process started or `ResetMetrics()` was last called, in
+// the shape of an `expvar` variable: `{"chunk_reads":{"<path or URL>":{"count":…,"bytes":…,…}},"chunk_reads_total":{…}}`.
+// Latencies are in milliseconds, and bytes only count successful reads.
+func DumpReadStats() []byte {
+	readStatsMutex.Lock()
+	defer readStatsMutex.Unlock()
+
+	sources := map[string]readStats{}
+	total := readStats{}
+	for source, stats := range readStatsBySource {
+		sources[source] = *stats
+		if total.Count == 0 || stats.MinLatencyMs < total.MinLatencyMs {
+			total.MinLatencyMs = stats.MinLatencyMs
+		}
+		total.MaxLatencyMs = max(total.MaxLatencyMs, stats.MaxLatencyMs)
+		total.Count += stats.Count
+		total.Errors += stats.Errors
+		total.Bytes += stats.Bytes
+		total.totalLatency += stats.totalLatency
+	}
+	if total.Count > 0 {
+		total.AvgLatencyMs = float64(total.totalLatency) / float64(time.Millisecond) / float64(total.Count)
+	}
+
+	json, _ := JSONMarshal(map[string]any{"chunk_reads": sources, "chunk_reads_total": total})
+	return json
+}
+
+// Forget all chunk reads counted so far by `DumpReadStats()`.
+func ResetMetrics() {
+	readStatsMutex.Lock()
+	defer readStatsMutex.Unlock()
+	readStatsBySource = map[string]*readStats{}
+}
@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"uosc/bins/src/ziggy/lib/testutil"
+)
+
+type readStatsDump struct {
+	ChunkReads      map[string]readStats `json:"chunk_reads"`
+	ChunkReadsTotal readStats            `json:"chunk_reads_total"`
+}
+
+func dumpReadStats(tb testing.TB) readStatsDump {
+	tb.Helper()
+	var dump readStatsDump
+	if err := json.Unmarshal(DumpReadStats(), &dump); err != nil {
+		tb.Fatal(err)
+	}
+	return dump
+}
+
+func TestDumpReadStats(t *testing.T) {
+	ResetMetrics()
+	defer ResetMetrics()
+
+	first := testutil.CreateSyntheticVideoFile(t, 1<<20)
+	second := testutil.CreateSyntheticVideoFile(t, 2<<20)
+	url := testutil.StartRangeServer(t, readSyntheticFile(t, 1<<20))
+	withCredentials := strings.Replace(url, "http://", "http://user:secret@", 1)
+	for _, path := range []string{first, first, second, withCredentials} {
+		if _, err := OSDBHashFile(path, WithReadStats()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Not counted without the option
+	if _, err := OSDBHashFile(second); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := dumpReadStats(t)
+	expected := map[string]int64{
+		first:  4,
+		second: 2,
+		strings.Replace(url, "http://", "http://user:xxxxx@", 1): 2,
+	}
+	if len(dump.ChunkReads) != len(expected) {
+		t.Errorf("stats are %v, expected one entry for each of %v", dump.ChunkReads, expected)
+	}
+	for source, count := range expected {
+		stats := dump.ChunkReads[source]
+		if stats.Count != count || stats.Bytes != count*OSDBChunkSize {
+			t.Errorf("%s has %d reads of %d bytes, expected %d of %d", source, stats.Count, stats.Bytes, count, count*OSDBChunkSize)
+		}
+	}
+	if dump.ChunkReadsTotal.Count != 8 {
+		t.Errorf("total count is %d, expected 8", dump.ChunkReadsTotal.Count)
+	}
+
+	ResetMetrics()
+	if dump := dumpReadStats(t); len(dump.ChunkReads) != 0 || dump.ChunkReadsTotal.Count != 0 {
+		t.Errorf("stats are %+v after a reset", dump)
+	}
+}
+
+func TestReadStatsOverflow(t *testing.T) {
+	ResetMetrics()
+	defer ResetMetrics()
+
+	read := func(offset int64, buf []byte) error { return nil }
+	for i := 0; i < maxReadStatsSources+10; i++ {
+		recordReads(newOptions([]Option{WithReadStats()}), fmt.Sprintf("/media/%d.mkv", i), read)(0, nil)
+	}
+	dump := dumpReadStats(t)
+	if len(dump.ChunkReads) != maxReadStatsSources+1 {
+		t.Errorf("%d sources are counted, expected %d and other", len(dump.ChunkReads), maxReadStatsSources)
+	}
+	if count := dump.ChunkReads["other"].Count; count != 10 {
+		t.Errorf("other has %d reads, expected 10", count)
+	}
+}
@@ -0,0 +1,141 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const MPEGTSPacketSize = 188
+
+// Captures the head and tail chunks of a stream of a known size as it's being written.
+type headTailWriter struct {
+	fileSize  int64
+	chunkSize int64
+	written   int64
+	buf       []byte
+}
+
+func newHeadTailWriter(fileSize int64, chunkSize int64) *headTailWriter {
+	return &headTailWriter{fileSize: fileSize, chunkSize: chunkSize, buf: make([]byte, chunkSize*2)}
+}
+
+func (w *headTailWriter) Write(p []byte) (n int, err error) {
+	start := w.written
+	end := start + int64(len(p))
+	w.written = end
+
+	// Head chunk
+	if start < w.chunkSize {
+		copy(w.buf[start:w.chunkSize], p)
+	}
+
+	// Tail chunk
+	tailStart := w.fileSize - w.chunkSize
+	if end > tailStart && start < w.fileSize {
+		from := max(start, tailStart)
+		to := min(end, w.fileSize)
+		copy(w.buf[w.chunkSize+from-tailStart:], p[from-start:to-start])
+	}
+
+	return len(p), nil
+}
+
+// Chunks captured so far, erroring when the stream size didn't match `fileSize`.
+func (w *headTailWriter) chunks() ([]byte, error) {
+	if w.written != w.fileSize {
+		return nil, fmt.Errorf("stream size %v doesn't match expected size %v", w.written, w.fileSize)
+	}
+	return w.buf, nil
+}
+
+// Generate an OSDB hash for an MPEG-TS stream without random access, such as UDP multicast.
+// Exactly `knownPacketCount` packets are consumed from `r`, and the hash is computed as if they were saved into
+// a file of `knownPacketCount * 188` bytes.
+func OSDBHashMPEGTS(r io.Reader, knownPacketCount int64) (string, error) {
+	fileSize := knownPacketCount * MPEGTSPacketSize
+	if fileSize < OSDBChunkSize {
+		return "", errors.New("stream is too small to generate a valid hash")
+	}
+
+	writer := newHeadTailWriter(fileSize, OSDBChunkSize)
+	packet := make([]byte, MPEGTSPacketSize)
+	for i := int64(0); i < knownPacketCount; i++ {
+		if _, err := io.ReadFull(r, packet); err != nil {
+			return "", fmt.Errorf("couldn't read packet %v: %w", i, err)
+		}
+		if packet[0] != 0x47 {
+			return "", fmt.Errorf("packet %v is missing the sync byte", i)
+		}
+		writer.Write(packet)
+	}
+
+	buf, err := writer.chunks()
+	if err != nil {
+		return "", err
+	}
+	return osdbHash(fileSize, buf), nil
+}
+
+// Copy `src` into `dst`, computing an OSDB hash of the copied data along the way, so it doesn't have to be read twice.
+// `fileSize` has to be known upfront, and the copy fails if `src` doesn't end up having that many bytes.
+func CopyWithHash(ctx context.Context, dst io.Writer, src io.Reader, fileSize int64) (hash string, n int64, err error) {
+	if fileSize < OSDBChunkSize {
+		return "", 0, errors.New("file is too small to generate a valid hash")
+	}
+
+	writer := newHeadTailWriter(fileSize, OSDBChunkSize)
+	n, err = io.Copy(io.MultiWriter(dst, writer), contextReader{ctx, src})
+	if err != nil {
+		return "", n, err
+	}
+
+	buf, err := writer.chunks()
+	if err != nil {
+		return "", n, err
+	}
+	return osdbHash(fileSize, buf), n, nil
+}
+
+// Stops reading once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Keeps the OSDB hash of a file that is still being written up to date, without re-reading its head chunk.
+type RollingHashUpdater struct {
+	headSum uint64
+	size    int64
+	hash    string
+}
+
+// `headChunk` are the first `OSDBChunkSize` bytes of the file, which don't change as it's being appended to.
+func NewRollingHashUpdater(headChunk []byte, currentSize int64) *RollingHashUpdater {
+	return &RollingHashUpdater{headSum: sumChunk(headChunk), size: currentSize}
+}
+
+// Recompute the hash from the last `OSDBChunkSize` bytes of the extended file.
+func (u *RollingHashUpdater) Update(newTailChunk []byte, newSize int64) string {
+	u.size = newSize
+	u.hash = formatOSDBHash(u.headSum + sumChunk(newTailChunk) + uint64(newSize))
+	return u.hash
+}
+
+// File size the hash was last computed for.
+func (u *RollingHashUpdater) Size() int64 {
+	return u.size
+}
+
+// Hash from the last `Update()` call, empty if there wasn't one yet.
+func (u *RollingHashUpdater) Hash() string {
+	return u.hash
+}
@@ -0,0 +1,98 @@
+package testutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Same methods as `lib.ChunkReader`, declared here so tests of `lib` itself can use this package without an
+// import cycle. Any `lib.ChunkReader` is one.
+type ChunkReader interface {
+	Size() (int64, error)
+	ReadChunk(offset int64, buf []byte) error
+}
+
+// Check that `cr` reads the same bytes as `expectedData`: its size, reads at the start and end, overlapping and
+// single byte reads, reads from several goroutines at once (as with `lib.WithReadAhead()`), and that reads past
+// the end fail rather than leaving part of the buffer unfilled. Returns all failed checks joined.
+func ValidateChunkReader(cr ChunkReader, expectedData []byte) error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	size, err := cr.Size()
+	if err != nil {
+		return fmt.Errorf("Size() failed: %w", err)
+	}
+	if size != int64(len(expectedData)) {
+		fail("Size() is %d, expected %d", size, len(expectedData))
+	}
+	if len(expectedData) == 0 {
+		return errors.Join(errs...)
+	}
+
+	// Buffers start as the complement of the expected bytes, so short reads can't go unnoticed.
+	read := func(offset, length int64) error {
+		buf := make([]byte, length)
+		for i := range buf {
+			buf[i] = ^expectedData[offset+int64(i)]
+		}
+		if err := cr.ReadChunk(offset, buf); err != nil {
+			return fmt.Errorf("ReadChunk(%d, %d bytes) failed: %w", offset, length, err)
+		}
+		if !bytes.Equal(buf, expectedData[offset:offset+length]) {
+			return fmt.Errorf("ReadChunk(%d, %d bytes) read the wrong bytes", offset, length)
+		}
+		return nil
+	}
+
+	dataSize := int64(len(expectedData))
+	chunkSize := min(65536, dataSize)
+	ranges := [][2]int64{
+		// Head and tail, as hashing reads them
+		{0, chunkSize},
+		{dataSize - chunkSize, chunkSize},
+		// Overlapping the previous reads
+		{dataSize / 4, max(dataSize/2, 1)},
+		{0, dataSize},
+		// Single bytes
+		{0, 1},
+		{dataSize / 2, 1},
+		{dataSize - 1, 1},
+	}
+	for _, r := range ranges {
+		if err := read(r[0], r[1]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	concurrentErrs := make([]error, 4)
+	for i := range concurrentErrs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			offset := [4]int64{0, dataSize - chunkSize, dataSize / 3, 2 * dataSize / 3}[i]
+			concurrentErrs[i] = read(offset, min(chunkSize, dataSize-offset))
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range concurrentErrs {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("concurrent %w", err))
+		}
+	}
+
+	// Reads past the end
+	if err := cr.ReadChunk(dataSize-1, make([]byte, 2)); err == nil {
+		fail("ReadChunk(%d, 2 bytes) past the end succeeded", dataSize-1)
+	}
+	if err := cr.ReadChunk(dataSize, make([]byte, 1)); err == nil {
+		fail("ReadChunk(%d, 1 byte) past the end succeeded", dataSize)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,23 @@
+package testutil
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Serve `data` over HTTP with `Content-Length` and `Accept-Ranges: bytes` support, and return its URL.
+// Server is stopped when the test finishes.
+func StartRangeServer(tb testing.TB, data []byte) (url string) {
+	tb.Helper()
+
+	modTime := time.Now()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+	}))
+	tb.Cleanup(server.Close)
+
+	return server.URL + "/file"
+}
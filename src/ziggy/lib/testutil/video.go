@@ -0,0 +1,76 @@
+package testutil
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Matroska EBML magic, so synthetic files pass `lib.IsVideoFile()`.
+var syntheticVideoMagic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+
+// Create a `size` bytes file in `tb.TempDir()` that starts like a Matroska file, followed by pseudo-random bytes
+// that are the same for every file of that size, and return its path. See `KnownHash()` for its OSDB hash.
+func CreateSyntheticVideoFile(tb testing.TB, size int64) string {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), fmt.Sprintf("synthetic-%d.mkv", size))
+	file, err := os.Create(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriterSize(file, 1<<20)
+	var word [8]byte
+	for offset := int64(0); offset < size; offset += 8 {
+		binary.LittleEndian.PutUint64(word[:], syntheticWord(size, offset/8))
+		writer.Write(word[:min(8, size-offset)])
+	}
+	if err := writer.Flush(); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// OSDB hash of the file `CreateSyntheticVideoFile()` creates for `size`, computed independently of `lib` so tests
+// can check it against `lib.OSDBHashFile()`. Files under 64k can't be OSDB hashed, so their hash is empty.
+func KnownHash(size int64) string {
+	const chunkSize = 65536
+	if size < chunkSize {
+		return ""
+	}
+
+	sum := uint64(size)
+	for _, start := range []int64{0, size - chunkSize} {
+		var word [8]byte
+		for offset := start; offset < start+chunkSize; offset += 8 {
+			for i := range word {
+				word[i] = syntheticByte(size, offset+int64(i))
+			}
+			sum += binary.LittleEndian.Uint64(word[:])
+		}
+	}
+	return fmt.Sprintf("%016x", sum)
+}
+
+func syntheticByte(size, offset int64) byte {
+	return byte(syntheticWord(size, offset/8) >> (8 * (offset % 8)))
+}
+
+// SplitMix64 of the word's index, seeded with the file size, so any part of a file can be generated on its own.
+func syntheticWord(size, index int64) uint64 {
+	z := uint64(size)*0x9E3779B97F4A7C15 + uint64(index+1)*0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	z ^= z >> 31
+
+	if index == 0 {
+		// Magic replaces the low bytes, which are written first.
+		z = z&^0xFFFFFFFF | uint64(binary.LittleEndian.Uint32(syntheticVideoMagic))
+	}
+	return z
+}
@@ -0,0 +1,521 @@
+package lib
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+const udfSectorSize = 2048
+
+// Descriptor tag identifiers.
+const (
+	udfTagAnchor            = 2
+	udfTagPartition         = 5
+	udfTagLogicalVolume     = 6
+	udfTagTerminating       = 8
+	udfTagFileSet           = 256
+	udfTagFileIdentifier    = 257
+	udfTagAllocationExtent  = 258
+	udfTagFileEntry         = 261
+	udfTagExtendedFileEntry = 266
+)
+
+const (
+	udfFileTypeDirectory     = 4
+	udfCharacteristicDeleted = 0x04
+	udfCharacteristicParent  = 0x08
+)
+
+const (
+	udfExtentTypeRecorded     = 0
+	udfExtentTypeContinuation = 3
+)
+
+// Physical byte range of a file's data in the image, `offset` is -1 for unrecorded extents that read as zeros.
+type udfExtent struct {
+	offset int64
+	length int64
+}
+
+type udfPartitionMap struct {
+	partitionNumber uint16
+	// Metadata partitions (UDF 2.50, used by Blu-ray) store their blocks inside the metadata file.
+	metadata         bool
+	metadataLocation uint32
+	metadataExtents  []udfExtent
+}
+
+type udfVolume struct {
+	r          io.ReaderAt
+	blockSize  int64
+	partitions map[uint16]int64
+	maps       []udfPartitionMap
+	root       udfFileEntry
+}
+
+type udfFileEntry struct {
+	fileType byte
+	size     int64
+	extents  []udfExtent
+}
+
+// Generate an OSDB hash for a file inside a UDF image, such as a Blu-ray `BDMV/STREAM/00000.m2ts`.
+func OSDBHashUDF(isoPath, entryPath string) (string, error) {
+	file, err := os.Open(isoPath)
+	if err != nil {
+		return "", errors.New("couldn't open file for hashing")
+	}
+	defer file.Close()
+
+	volume, err := openUDFVolume(file)
+	if err != nil {
+		return "", err
+	}
+	entry, err := volume.lookup(entryPath)
+	if err != nil {
+		return "", err
+	}
+	if entry.fileType == udfFileTypeDirectory {
+		return "", fmt.Errorf("\"%s\" is a directory", entryPath)
+	}
+
+	// Files recorded in one piece can be hashed as a range of the image.
+	if len(entry.extents) == 1 && entry.extents[0].offset >= 0 {
+		start := entry.extents[0].offset
+		return OSDBHashFileRange(isoPath, start, start+entry.size)
+	}
+
+	spans := []chunkInfo{
+		{0, OSDBChunkSize},
+		{-OSDBChunkSize, OSDBChunkSize},
+	}
+	reader := &udfFileReader{r: file, extents: entry.extents, size: entry.size}
+	fileSize, buf, err := readReaderChunks(reader, "", newOptions(nil), OSDBChunkSize, spans...)
+	if err != nil {
+		return "", err
+	}
+	return osdbHash(fileSize, buf), nil
+}
+
+func readUDFDescriptor(r io.ReaderAt, offset int64, size int, tag uint16) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	if got := binary.LittleEndian.Uint16(data[0:2]); got != tag {
+		return nil, fmt.Errorf("expected UDF descriptor %v at %v, found %v", tag, offset, got)
+	}
+	return data, nil
+}
+
+func openUDFVolume(r io.ReaderAt) (*udfVolume, error) {
+	anchor, err := readUDFDescriptor(r, 256*udfSectorSize, udfSectorSize, udfTagAnchor)
+	if err != nil {
+		return nil, errors.New("not a UDF image")
+	}
+	sequenceLength := int64(binary.LittleEndian.Uint32(anchor[16:20]))
+	sequenceStart := int64(binary.LittleEndian.Uint32(anchor[20:24])) * udfSectorSize
+
+	volume := &udfVolume{r: r, partitions: map[uint16]int64{}}
+	var fileSet []byte
+	for offset := sequenceStart; offset < sequenceStart+sequenceLength; offset += udfSectorSize {
+		data := make([]byte, udfSectorSize)
+		if _, err := r.ReadAt(data, offset); err != nil {
+			return nil, err
+		}
+		tag := binary.LittleEndian.Uint16(data[0:2])
+		if tag == udfTagTerminating {
+			break
+		}
+		switch tag {
+		case udfTagPartition:
+			number := binary.LittleEndian.Uint16(data[22:24])
+			volume.partitions[number] = int64(binary.LittleEndian.Uint32(data[188:192])) * udfSectorSize
+		case udfTagLogicalVolume:
+			volume.blockSize = int64(binary.LittleEndian.Uint32(data[212:216]))
+			fileSet = data[248:264]
+			volume.maps, err = parseUDFPartitionMaps(data)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if volume.blockSize == 0 || len(volume.maps) == 0 {
+		return nil, errors.New("UDF image has no logical volume")
+	}
+
+	for i, m := range volume.maps {
+		if !m.metadata {
+			continue
+		}
+		physical := udfPartitionMap{partitionNumber: m.partitionNumber}
+		offset, err := volume.blockOffset(physical, m.metadataLocation)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := volume.readFileEntry(offset, physical)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read UDF metadata file: %w", err)
+		}
+		volume.maps[i].metadataExtents = entry.extents
+	}
+
+	fileSetOffset, err := volume.longADOffset(fileSet)
+	if err != nil {
+		return nil, err
+	}
+	fsd, err := readUDFDescriptor(r, fileSetOffset, 512, udfTagFileSet)
+	if err != nil {
+		return nil, err
+	}
+	volume.root, err = volume.readICB(fsd[400:416])
+	return volume, err
+}
+
+func parseUDFPartitionMaps(lvd []byte) (maps []udfPartitionMap, err error) {
+	count := int(binary.LittleEndian.Uint32(lvd[268:272]))
+	data := lvd[440:]
+	for i := 0; i < count; i++ {
+		if len(data) < 2 || int(data[1]) > len(data) || data[1] < 6 {
+			return nil, errors.New("invalid UDF partition map")
+		}
+		entry := data[:data[1]]
+		data = data[data[1]:]
+		switch entry[0] {
+		case 1:
+			maps = append(maps, udfPartitionMap{partitionNumber: binary.LittleEndian.Uint16(entry[4:6])})
+		case 2:
+			if len(entry) < 44 {
+				return nil, errors.New("invalid UDF partition map")
+			}
+			m := udfPartitionMap{partitionNumber: binary.LittleEndian.Uint16(entry[38:40])}
+			// Sparable partitions are addressed like physical ones, virtual partitions only exist on CD-R.
+			switch identifier := strings.TrimRight(string(entry[5:28]), "\x00"); identifier {
+			case "*UDF Metadata Partition":
+				m.metadata = true
+				m.metadataLocation = binary.LittleEndian.Uint32(entry[40:44])
+			case "*UDF Virtual Partition":
+				return nil, errors.New("virtual UDF partitions are not supported")
+			}
+			maps = append(maps, m)
+		default:
+			return nil, fmt.Errorf("unknown UDF partition map type %v", entry[0])
+		}
+	}
+	return maps, nil
+}
+
+// Offset of a logical block in the image.
+func (v *udfVolume) blockOffset(m udfPartitionMap, block uint32) (int64, error) {
+	if m.metadata {
+		extents := mapUDFRange(m.metadataExtents, int64(block)*v.blockSize, v.blockSize)
+		if len(extents) != 1 || extents[0].offset < 0 {
+			return 0, fmt.Errorf("UDF metadata block %v is out of bounds", block)
+		}
+		return extents[0].offset, nil
+	}
+	start, ok := v.partitions[m.partitionNumber]
+	if !ok {
+		return 0, fmt.Errorf("UDF partition %v doesn't exist", m.partitionNumber)
+	}
+	return start + int64(block)*v.blockSize, nil
+}
+
+// Physical extents of `[block, block + length)` in a partition.
+func (v *udfVolume) blockRange(m udfPartitionMap, block uint32, length int64) ([]udfExtent, error) {
+	if m.metadata {
+		extents := mapUDFRange(m.metadataExtents, int64(block)*v.blockSize, length)
+		if sumUDFExtents(extents) != length {
+			return nil, fmt.Errorf("UDF metadata block %v is out of bounds", block)
+		}
+		return extents, nil
+	}
+	offset, err := v.blockOffset(m, block)
+	return []udfExtent{{offset, length}}, err
+}
+
+func (v *udfVolume) partitionMap(ref uint16) (udfPartitionMap, error) {
+	if int(ref) >= len(v.maps) {
+		return udfPartitionMap{}, fmt.Errorf("UDF partition reference %v doesn't exist", ref)
+	}
+	return v.maps[ref], nil
+}
+
+// Offset of the block a long allocation descriptor points to.
+func (v *udfVolume) longADOffset(ad []byte) (int64, error) {
+	m, err := v.partitionMap(binary.LittleEndian.Uint16(ad[8:10]))
+	if err != nil {
+		return 0, err
+	}
+	return v.blockOffset(m, binary.LittleEndian.Uint32(ad[4:8]))
+}
+
+// Read the file entry a long allocation descriptor points to.
+func (v *udfVolume) readICB(ad []byte) (udfFileEntry, error) {
+	m, err := v.partitionMap(binary.LittleEndian.Uint16(ad[8:10]))
+	if err != nil {
+		return udfFileEntry{}, err
+	}
+	offset, err := v.blockOffset(m, binary.LittleEndian.Uint32(ad[4:8]))
+	if err != nil {
+		return udfFileEntry{}, err
+	}
+	return v.readFileEntry(offset, m)
+}
+
+// Read a (extended) file entry, short allocation descriptors in it are relative to partition `m`.
+func (v *udfVolume) readFileEntry(offset int64, m udfPartitionMap) (entry udfFileEntry, err error) {
+	data := make([]byte, v.blockSize)
+	if _, err := v.r.ReadAt(data, offset); err != nil {
+		return entry, err
+	}
+
+	var lengthsOffset int
+	switch binary.LittleEndian.Uint16(data[0:2]) {
+	case udfTagFileEntry:
+		lengthsOffset = 168
+	case udfTagExtendedFileEntry:
+		lengthsOffset = 208
+	default:
+		return entry, fmt.Errorf("expected UDF file entry at %v", offset)
+	}
+
+	entry.fileType = data[27]
+	entry.size = int64(binary.LittleEndian.Uint64(data[56:64]))
+	adType := binary.LittleEndian.Uint16(data[34:36]) & 0x7
+
+	// Extended attributes come before allocation descriptors, and aren't needed for reading the file.
+	eaLength := int(binary.LittleEndian.Uint32(data[lengthsOffset : lengthsOffset+4]))
+	adLength := int(binary.LittleEndian.Uint32(data[lengthsOffset+4 : lengthsOffset+8]))
+	adStart := lengthsOffset + 8 + eaLength
+	if eaLength < 0 || adLength < 0 || adStart+adLength > len(data) {
+		return entry, fmt.Errorf("invalid UDF file entry at %v", offset)
+	}
+
+	if adType == 3 {
+		// Data is embedded in the file entry itself
+		entry.extents = []udfExtent{{offset + int64(adStart), min(int64(adLength), entry.size)}}
+		return entry, nil
+	}
+	entry.extents, err = v.readAllocationDescriptors(data[adStart:adStart+adLength], adType, m)
+	if err != nil {
+		return entry, err
+	}
+	entry.extents = mapUDFRange(entry.extents, 0, entry.size)
+	return entry, nil
+}
+
+func (v *udfVolume) readAllocationDescriptors(data []byte, adType uint16, m udfPartitionMap) (extents []udfExtent, err error) {
+	var adSize int
+	switch adType {
+	case 0:
+		adSize = 8
+	case 1:
+		adSize = 16
+	default:
+		return nil, fmt.Errorf("unsupported UDF allocation descriptor type %v", adType)
+	}
+
+	for ; len(data) >= adSize; data = data[adSize:] {
+		ad := data[:adSize]
+		rawLength := binary.LittleEndian.Uint32(ad[0:4])
+		length := int64(rawLength & 0x3FFFFFFF)
+		extentType := rawLength >> 30
+		if length == 0 {
+			break
+		}
+
+		block := binary.LittleEndian.Uint32(ad[4:8])
+		partition := m
+		if adType == 1 {
+			if partition, err = v.partitionMap(binary.LittleEndian.Uint16(ad[8:10])); err != nil {
+				return nil, err
+			}
+		}
+
+		switch extentType {
+		case udfExtentTypeRecorded:
+			recorded, err := v.blockRange(partition, block, length)
+			if err != nil {
+				return nil, err
+			}
+			extents = append(extents, recorded...)
+		case udfExtentTypeContinuation:
+			offset, err := v.blockOffset(partition, block)
+			if err != nil {
+				return nil, err
+			}
+			aed, err := readUDFDescriptor(v.r, offset, int(min(length, v.blockSize)), udfTagAllocationExtent)
+			if err != nil {
+				return nil, err
+			}
+			adLength := int(binary.LittleEndian.Uint32(aed[20:24]))
+			if 24+adLength > len(aed) {
+				return nil, fmt.Errorf("invalid UDF allocation extent at %v", offset)
+			}
+			rest, err := v.readAllocationDescriptors(aed[24:24+adLength], adType, partition)
+			return append(extents, rest...), err
+		default:
+			// Allocated or not, unrecorded extents read as zeros
+			extents = append(extents, udfExtent{-1, length})
+		}
+	}
+	return mergeUDFExtents(extents), nil
+}
+
+// Resolve a path like `BDMV/STREAM/00000.m2ts`, falling back to case-insensitive matches.
+func (v *udfVolume) lookup(entryPath string) (entry udfFileEntry, err error) {
+	entry = v.root
+	for _, name := range strings.FieldsFunc(entryPath, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if entry.fileType != udfFileTypeDirectory {
+			return entry, fmt.Errorf("\"%s\" not found", entryPath)
+		}
+		entry, err = v.lookupChild(entry, name)
+		if err != nil {
+			return entry, fmt.Errorf("\"%s\" not found: %w", entryPath, err)
+		}
+	}
+	return entry, nil
+}
+
+func (v *udfVolume) lookupChild(dir udfFileEntry, name string) (udfFileEntry, error) {
+	if dir.size > 64<<20 {
+		return udfFileEntry{}, errors.New("directory is too large")
+	}
+	data := make([]byte, dir.size)
+	if err := readUDFExtents(v.r, dir.extents, 0, data); err != nil {
+		return udfFileEntry{}, err
+	}
+
+	var folded []byte
+	for len(data) >= 38 {
+		if binary.LittleEndian.Uint16(data[0:2]) != udfTagFileIdentifier {
+			return udfFileEntry{}, errors.New("invalid UDF file identifier")
+		}
+		characteristics := data[18]
+		identifierLength := int(data[19])
+		implementationLength := int(binary.LittleEndian.Uint16(data[36:38]))
+		identifierStart := 38 + implementationLength
+		size := (identifierStart + identifierLength + 3) &^ 3
+		if size > len(data) {
+			return udfFileEntry{}, errors.New("invalid UDF file identifier")
+		}
+		icb := data[20:36]
+		identifier := decodeUDFString(data[identifierStart : identifierStart+identifierLength])
+		data = data[size:]
+
+		if characteristics&(udfCharacteristicDeleted|udfCharacteristicParent) != 0 {
+			continue
+		}
+		if identifier == name {
+			return v.readICB(icb)
+		}
+		if folded == nil && strings.EqualFold(identifier, name) {
+			folded = icb
+		}
+	}
+	if folded != nil {
+		return v.readICB(folded)
+	}
+	return udfFileEntry{}, fmt.Errorf("no entry named \"%s\"", name)
+}
+
+// Decode an OSTA compressed unicode string, which has 8 or 16 bit characters depending on its first byte.
+func decodeUDFString(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	switch data[0] {
+	case 8:
+		chars := make([]rune, len(data)-1)
+		for i, b := range data[1:] {
+			chars[i] = rune(b)
+		}
+		return string(chars)
+	case 16:
+		units := make([]uint16, (len(data)-1)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(data[1+2*i:])
+		}
+		return string(utf16.Decode(units))
+	}
+	return ""
+}
+
+func mergeUDFExtents(extents []udfExtent) (merged []udfExtent) {
+	for _, extent := range extents {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if (last.offset < 0 && extent.offset < 0) || (last.offset >= 0 && last.offset+last.length == extent.offset) {
+				last.length += extent.length
+				continue
+			}
+		}
+		merged = append(merged, extent)
+	}
+	return merged
+}
+
+func sumUDFExtents(extents []udfExtent) (total int64) {
+	for _, extent := range extents {
+		total += extent.length
+	}
+	return total
+}
+
+// Physical extents backing the `[offset, offset + length)` range of data laid out over `extents`.
+func mapUDFRange(extents []udfExtent, offset, length int64) (mapped []udfExtent) {
+	for _, extent := range extents {
+		if length <= 0 {
+			break
+		}
+		if offset >= extent.length {
+			offset -= extent.length
+			continue
+		}
+		n := min(extent.length-offset, length)
+		start := int64(-1)
+		if extent.offset >= 0 {
+			start = extent.offset + offset
+		}
+		mapped = append(mapped, udfExtent{start, n})
+		offset, length = 0, length-n
+	}
+	return mapped
+}
+
+func readUDFExtents(r io.ReaderAt, extents []udfExtent, offset int64, buf []byte) error {
+	for _, extent := range mapUDFRange(extents, offset, int64(len(buf))) {
+		part := buf[:extent.length]
+		if extent.offset < 0 {
+			clear(part)
+		} else if _, err := r.ReadAt(part, extent.offset); err != nil {
+			return err
+		}
+		buf = buf[extent.length:]
+	}
+	if len(buf) != 0 {
+		return errors.New("read past the end of UDF file")
+	}
+	return nil
+}
+
+// ChunkReader of a fragmented file inside a UDF image.
+type udfFileReader struct {
+	r       io.ReaderAt
+	extents []udfExtent
+	size    int64
+}
+
+func (r *udfFileReader) Size() (int64, error) {
+	return r.size, nil
+}
+
+func (r *udfFileReader) ReadChunk(offset int64, buf []byte) error {
+	return readUDFExtents(r.r, r.extents, offset, buf)
+}
@@ -0,0 +1,318 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+
+	"uosc/bins/src/ziggy/lib/testutil"
+)
+
+const (
+	udfTestPartitionStart = 260
+	udfTestImageSectors   = 700
+)
+
+// Minimal UDF image, with just the descriptors `openUDFVolume()` reads.
+type udfTestImage struct {
+	data []byte
+	// Metadata (file set, file entries, and directories) is in a UDF 2.50 metadata partition, and file data is
+	// addressed with long allocation descriptors, as on Blu-rays. Otherwise everything is in one partition,
+	// addressed with short ones.
+	metadata bool
+}
+
+func (img *udfTestImage) sector(n int64) []byte {
+	return img.data[n*udfSectorSize : (n+1)*udfSectorSize]
+}
+
+// Physical partition block.
+func (img *udfTestImage) block(n uint32) []byte {
+	return img.sector(udfTestPartitionStart + int64(n))
+}
+
+// Metadata partition block, which comes right after the metadata file entry in block 0.
+func (img *udfTestImage) metaBlock(n uint32) []byte {
+	if img.metadata {
+		return img.block(n + 1)
+	}
+	return img.block(n)
+}
+
+func (img *udfTestImage) metaRef() uint16 {
+	if img.metadata {
+		return 1
+	}
+	return 0
+}
+
+func (img *udfTestImage) adType() uint16 {
+	if img.metadata {
+		return 1
+	}
+	return 0
+}
+
+func udfLongAD(length uint32, block uint32, ref uint16) []byte {
+	ad := binary.LittleEndian.AppendUint32(nil, length)
+	ad = binary.LittleEndian.AppendUint32(ad, block)
+	ad = binary.LittleEndian.AppendUint16(ad, ref)
+	return append(ad, make([]byte, 6)...)
+}
+
+// Allocation descriptor of file data in the physical partition, `length` can have the extent type in its top bits.
+func (img *udfTestImage) dataAD(length uint32, block uint32) []byte {
+	if img.metadata {
+		return udfLongAD(length, block, 0)
+	}
+	return udfLongAD(length, block, 0)[:8]
+}
+
+// Allocation descriptor of a metadata block, such as a directory's.
+func (img *udfTestImage) metaAD(length uint32, block uint32) []byte {
+	if img.metadata {
+		return udfLongAD(length, block, 1)
+	}
+	return udfLongAD(length, block, 0)[:8]
+}
+
+func putUDFFileEntry(b []byte, extended bool, fileType byte, size int64, adType uint16, ads []byte) {
+	lengthsOffset := 168
+	binary.LittleEndian.PutUint16(b, udfTagFileEntry)
+	if extended {
+		lengthsOffset = 208
+		binary.LittleEndian.PutUint16(b, udfTagExtendedFileEntry)
+	}
+	b[27] = fileType
+	binary.LittleEndian.PutUint16(b[34:], adType)
+	binary.LittleEndian.PutUint64(b[56:], uint64(size))
+	binary.LittleEndian.PutUint32(b[lengthsOffset+4:], uint32(len(ads)))
+	copy(b[lengthsOffset+8:], ads)
+}
+
+func udfFileIdentifier(name string, characteristics byte, icb []byte) []byte {
+	identifier := []byte{8}
+	for _, r := range name {
+		if r > 0xFF {
+			identifier = []byte{16}
+			for _, unit := range utf16.Encode([]rune(name)) {
+				identifier = binary.BigEndian.AppendUint16(identifier, unit)
+			}
+			break
+		}
+		identifier = append(identifier, byte(r))
+	}
+	if name == "" {
+		identifier = nil
+	}
+
+	fid := make([]byte, 38)
+	binary.LittleEndian.PutUint16(fid, udfTagFileIdentifier)
+	fid[18] = characteristics
+	fid[19] = byte(len(identifier))
+	copy(fid[20:36], icb)
+	fid = append(fid, identifier...)
+	return append(fid, make([]byte, (4-len(fid)%4)%4)...)
+}
+
+func (img *udfTestImage) putDirectory(entryBlock, dataBlock uint32, fids ...[]byte) {
+	data := bytes.Join(fids, nil)
+	copy(img.metaBlock(dataBlock), data)
+	putUDFFileEntry(img.metaBlock(entryBlock), false, udfFileTypeDirectory, int64(len(data)), img.adType(),
+		img.metaAD(uint32(len(data)), dataBlock))
+}
+
+// Write an image with `BDMV/00000.m2ts` recorded in one piece, `BDMV/frag.m2ts` in two pieces with a gap between
+// them, and `BDMV/cont.m2ts` in two pieces listed in a continuation extent.
+func writeUDFTestImage(tb testing.TB, metadata bool, contiguous, first, gap, second []byte) string {
+	tb.Helper()
+	img := &udfTestImage{data: make([]byte, udfTestImageSectors*udfSectorSize), metadata: metadata}
+	const contiguousBlock, firstBlock, secondBlock = 20, 200, 300
+
+	anchor := img.sector(256)
+	binary.LittleEndian.PutUint16(anchor, udfTagAnchor)
+	binary.LittleEndian.PutUint32(anchor[16:], 3*udfSectorSize)
+	binary.LittleEndian.PutUint32(anchor[20:], 257)
+
+	partition := img.sector(257)
+	binary.LittleEndian.PutUint16(partition, udfTagPartition)
+	binary.LittleEndian.PutUint32(partition[188:], udfTestPartitionStart)
+
+	lvd := img.sector(258)
+	binary.LittleEndian.PutUint16(lvd, udfTagLogicalVolume)
+	binary.LittleEndian.PutUint32(lvd[212:], udfSectorSize)
+	copy(lvd[248:], udfLongAD(udfSectorSize, 0, img.metaRef()))
+	binary.LittleEndian.PutUint32(lvd[268:], 1)
+	copy(lvd[440:], []byte{1, 6, 1, 0, 0, 0})
+	if metadata {
+		binary.LittleEndian.PutUint32(lvd[268:], 2)
+		m := lvd[446 : 446+64]
+		m[0], m[1] = 2, 64
+		copy(m[5:], "*UDF Metadata Partition")
+		binary.LittleEndian.PutUint32(m[40:], 0)
+		// Metadata file covers the 10 blocks after its entry.
+		putUDFFileEntry(img.block(0), true, 250, 10*udfSectorSize, 0, udfLongAD(10*udfSectorSize, 1, 0)[:8])
+	}
+
+	binary.LittleEndian.PutUint16(img.sector(259), udfTagTerminating)
+
+	fsd := img.metaBlock(0)
+	binary.LittleEndian.PutUint16(fsd, udfTagFileSet)
+	copy(fsd[400:], udfLongAD(udfSectorSize, 1, img.metaRef()))
+
+	icb := func(block uint32) []byte { return udfLongAD(udfSectorSize, block, img.metaRef()) }
+	img.putDirectory(1, 2,
+		udfFileIdentifier("", udfCharacteristicParent, icb(1)),
+		udfFileIdentifier("BDMV", 0, icb(3)),
+	)
+	img.putDirectory(3, 4,
+		udfFileIdentifier("", udfCharacteristicParent, icb(1)),
+		// Same name as a live entry, and pointing at an empty block, so it fails the test if it's not skipped.
+		udfFileIdentifier("00000.m2ts", udfCharacteristicDeleted, icb(9)),
+		udfFileIdentifier("00000.m2ts", 0, icb(5)),
+		udfFileIdentifier("frag.m2ts", 0, icb(6)),
+		udfFileIdentifier("cont.m2ts", 0, icb(7)),
+		udfFileIdentifier("Фильм.mkv", 0, icb(5)),
+	)
+
+	copy(img.data[(udfTestPartitionStart+contiguousBlock)*udfSectorSize:], contiguous)
+	copy(img.data[(udfTestPartitionStart+firstBlock)*udfSectorSize:], first)
+	copy(img.data[(udfTestPartitionStart+secondBlock)*udfSectorSize:], second)
+
+	putUDFFileEntry(img.metaBlock(5), false, 5, int64(len(contiguous)), img.adType(),
+		img.dataAD(uint32(len(contiguous)), contiguousBlock))
+
+	putUDFFileEntry(img.metaBlock(6), true, 5, int64(len(first)+len(gap)+len(second)), img.adType(), bytes.Join([][]byte{
+		img.dataAD(uint32(len(first)), firstBlock),
+		img.dataAD(uint32(len(gap))|1<<30, 0),
+		img.dataAD(uint32(len(second)), secondBlock),
+	}, nil))
+
+	putUDFFileEntry(img.metaBlock(7), false, 5, int64(len(first)+len(second)), img.adType(), bytes.Join([][]byte{
+		img.dataAD(uint32(len(first)), firstBlock),
+		img.metaAD(udfSectorSize|3<<30, 8),
+	}, nil))
+	aed := img.metaBlock(8)
+	binary.LittleEndian.PutUint16(aed, udfTagAllocationExtent)
+	secondAD := img.dataAD(uint32(len(second)), secondBlock)
+	binary.LittleEndian.PutUint32(aed[20:], uint32(len(secondAD)))
+	copy(aed[24:], secondAD)
+
+	path := filepath.Join(tb.TempDir(), "disc.iso")
+	if err := os.WriteFile(path, img.data, 0666); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+func TestOSDBHashUDF(t *testing.T) {
+	contiguous := readSyntheticFile(t, 300001)
+	first := readSyntheticFile(t, 20*udfSectorSize)
+	gap := make([]byte, 20*udfSectorSize)
+	second := readSyntheticFile(t, 40*udfSectorSize+100)
+
+	fragmentedHash, err := OSDBHashBytes(bytes.Join([][]byte{first, gap, second}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	continuedHash, err := OSDBHashBytes(bytes.Join([][]byte{first, second}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+		wantErr  bool
+	}{
+		{path: "BDMV/00000.m2ts", expected: testutil.KnownHash(int64(len(contiguous)))},
+		{path: "/bdmv/00000.M2TS", expected: testutil.KnownHash(int64(len(contiguous)))},
+		{path: `BDMV\frag.m2ts`, expected: fragmentedHash},
+		{path: "BDMV/cont.m2ts", expected: continuedHash},
+		{path: "BDMV/Фильм.mkv", expected: testutil.KnownHash(int64(len(contiguous)))},
+		{path: "BDMV", wantErr: true},
+		{path: "BDMV/missing.m2ts", wantErr: true},
+		{path: "BDMV/00000.m2ts/file", wantErr: true},
+	}
+	for _, metadata := range []bool{false, true} {
+		name := "physical partition"
+		if metadata {
+			name = "metadata partition"
+		}
+		t.Run(name, func(t *testing.T) {
+			image := writeUDFTestImage(t, metadata, contiguous, first, gap, second)
+			for _, tt := range tests {
+				t.Run(tt.path, func(t *testing.T) {
+					hash, err := OSDBHashUDF(image, tt.path)
+					if tt.wantErr {
+						if err == nil {
+							t.Errorf("succeeded with %s", hash)
+						}
+						return
+					}
+					if err != nil {
+						t.Fatal(err)
+					}
+					if hash != tt.expected {
+						t.Errorf("hash is %s, expected %s", hash, tt.expected)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestOSDBHashUDFNotUDF(t *testing.T) {
+	path := testutil.CreateSyntheticVideoFile(t, 1<<20)
+	if hash, err := OSDBHashUDF(path, "BDMV/00000.m2ts"); err == nil {
+		t.Errorf("succeeded with %s", hash)
+	}
+}
+
+func TestMapUDFRange(t *testing.T) {
+	extents := []udfExtent{{1000, 100}, {-1, 50}, {5000, 200}}
+	tests := []struct {
+		name           string
+		offset, length int64
+		expected       []udfExtent
+	}{
+		{"whole", 0, 350, []udfExtent{{1000, 100}, {-1, 50}, {5000, 200}}},
+		{"inside one extent", 10, 20, []udfExtent{{1010, 20}}},
+		{"across extents", 90, 100, []udfExtent{{1090, 10}, {-1, 50}, {5000, 40}}},
+		{"starting in a gap", 120, 40, []udfExtent{{-1, 30}, {5000, 10}}},
+		{"past the end", 340, 20, []udfExtent{{5190, 10}}},
+		{"after the end", 400, 20, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapped := mapUDFRange(extents, tt.offset, tt.length)
+			if len(mapped) != len(tt.expected) {
+				t.Fatalf("got %v, expected %v", mapped, tt.expected)
+			}
+			for i := range mapped {
+				if mapped[i] != tt.expected[i] {
+					t.Fatalf("got %v, expected %v", mapped, tt.expected)
+				}
+			}
+		})
+	}
+}
+
+func TestUDFFileReader(t *testing.T) {
+	image := bytes.Repeat([]byte{0xAA}, 4096)
+	copy(image[100:], bytes.Repeat([]byte{1}, 100))
+	copy(image[3000:], bytes.Repeat([]byte{2}, 1000))
+	expected := bytes.Join([][]byte{bytes.Repeat([]byte{1}, 100), make([]byte, 50), bytes.Repeat([]byte{2}, 1000)}, nil)
+
+	reader := &udfFileReader{
+		r:       bytes.NewReader(image),
+		extents: []udfExtent{{100, 100}, {-1, 50}, {3000, 1000}},
+		size:    int64(len(expected)),
+	}
+	if err := testutil.ValidateChunkReader(reader, expected); err != nil {
+		t.Error(err)
+	}
+}
@@ -3,27 +3,52 @@ package lib
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Response of commands, and of the hash proxy on errors. Write it with `JSONMarshal()`, as `json.Marshal()` HTML
+// escapes `&,<,>` in messages whatever `MarshalJSON()` returns.
 type ErrorData struct {
 	Error   bool   `json:"error"`
 	Message string `json:"message"`
 }
 
+func NewErrorData(err error) ErrorData {
+	return ErrorData{Error: true, Message: err.Error()}
+}
+
+func NewSuccessData(message string) ErrorData {
+	return ErrorData{Error: false, Message: message}
+}
+
+// Serialize with `JSONMarshal()`, so `&,<,>` in messages aren't escaped here. Only encoders with HTML escaping
+// turned off, such as `JSONMarshal()`, keep them that way.
+func (data ErrorData) MarshalJSON() ([]byte, error) {
+	// Alias without methods, otherwise this would recurse
+	type errorData ErrorData
+	json, err := JSONMarshal(errorData(data))
+	return bytes.TrimSuffix(json, []byte("\n")), err
+}
+
 func Check(err error) {
 	if err != nil {
-		res := ErrorData{Error: true, Message: err.Error()}
-		json, err := json.Marshal(res)
+		json, err := JSONMarshal(NewErrorData(err))
 		if err != nil {
 			panic(err)
 		}
@@ -37,37 +62,109 @@ func Must[T any](t T, err error) T {
 	return t
 }
 
+// Same as `Check()`, but panics with the JSON of the error's `ErrorData` instead of printing it and exiting.
+// Commands should use `Check()`, library code and tests `Panic()`, so a failure doesn't end the whole process.
+func Panic(err error) {
+	if err != nil {
+		json, jsonErr := JSONMarshal(NewErrorData(err))
+		if jsonErr != nil {
+			panic(jsonErr)
+		}
+		panic(string(bytes.TrimSuffix(json, []byte("\n"))))
+	}
+}
+
+// Same as `Must()`, but panics like `Panic()`.
+func MustPanic[T any](t T, err error) T {
+	Panic(err)
+	return t
+}
+
 const OSDBChunkSize = 65536 // 64k
 
+var ErrBufferTooSmall = errors.New("buffer is too small to hold both hash chunks")
+
+var ErrRangeNotSupported = errors.New("URL doesn't support range fetch")
+
+var ErrInvalidHash = errors.New("OSDB hash must be 16 hex characters")
+
+var ErrMMSNotSupported = errors.New("mms:// URLs are not supported")
+
 type chunkInfo struct {
 	offset int64
 	size   int64
 }
 
-func readRemoteChunks(url string, minimumRequiredSize int64, chunks ...chunkInfo) (fileSize int64, buf []byte, err error) {
-	client := &http.Client{}
+func readRemoteChunks(url string, opts Options, minimumRequiredSize int64, chunks ...chunkInfo) (fileSize int64, buf []byte, header http.Header, err error) {
+	url, err = CanonicaliseURL(url)
+	if err != nil {
+		return
+	}
+
+	client, release := newHTTPClient(opts)
+	defer release()
 
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancelFunc := context.WithTimeout(optionsContext(opts), opts.Timeout)
 	defer cancelFunc()
 
+	if opts.RespectRobots {
+		if err = checkRobots(ctx, client, url); err != nil {
+			return
+		}
+	}
+
 	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
 		return
 	}
 
+	etag, cachedSize, cachedBuf, cached := lookupCachedChunks(opts.ETagStore, url, chunks)
+	if cached {
+		req.Header.Set("If-None-Match", etag)
+	}
+
 	res, err := client.Do(req)
 	if err != nil {
 		return
 	}
 
-	header := res.Header
+	header = res.Header
+	if opts.RespectRobots {
+		if err = checkRobotsTag(header); err != nil {
+			return
+		}
+	}
+
+	if cached && res.StatusCode == http.StatusNotModified {
+		if cachedSize < minimumRequiredSize {
+			err = errors.New("file is too small to generate a valid hash")
+			return
+		}
+		buf, _ = layoutChunks(opts.buffer, cachedSize, chunks)
+		copy(buf, cachedBuf)
+		if opts.fromCache != nil {
+			*opts.fromCache = true
+		}
+		return cachedSize, buf, header, nil
+	}
+
+	if !opts.inMPD && isMPDResponse(url, header) {
+		return readMPDChunks(ctx, client, url, opts, minimumRequiredSize, chunks...)
+	}
+
 	if accept_ranges, ok := header["Accept-Ranges"]; !ok || accept_ranges[0] != "bytes" {
-		err = errors.New("URL doesn't support range fetch")
-		return
+		return readWholeRemoteFile(ctx, client, url, opts, header, minimumRequiredSize, chunks...)
 	}
 
-	fileSize, err = strconv.ParseInt(header["Content-Length"][0], 10, 64)
-	if err != nil {
+	if contentLength := header.Get("Content-Length"); contentLength != "" {
+		fileSize, err = strconv.ParseInt(contentLength, 10, 64)
+		if err != nil {
+			return
+		}
+	} else if opts.SizeHint > 0 {
+		fileSize = opts.SizeHint
+	} else {
+		err = errors.New("URL doesn't report its size, try passing a size hint")
 		return
 	}
 
@@ -76,30 +173,182 @@ func readRemoteChunks(url string, minimumRequiredSize int64, chunks ...chunkInfo
 		return
 	}
 
-	totalBufferNeeded := int64(0)
-	for _, span := range chunks {
-		totalBufferNeeded += span.size
+	fill := fillChunks
+	if opts.DeterministicOrder {
+		fill = fillChunksInOrder
+	} else if opts.ReadAhead {
+		fill = fillChunksConcurrently
+	}
+	buf, err = fill(opts.buffer, fileSize, chunks, recordReads(opts, url, limitHostReads(opts, url, func(offset int64, chunk []byte) error {
+		start := time.Now()
+		err := readRemoteChunk(ctx, client, url, offset, chunk, opts.MaxRetries)
+		if opts.RangeLog != nil {
+			logRange(opts.RangeLog, url, offset, chunk, time.Since(start), err)
+		}
+		return err
+	})))
+	if err == nil && opts.ETagStore != nil {
+		if etag := header.Get("ETag"); etag != "" {
+			opts.ETagStore.Set(url, etag, encodeCachedChunks(fileSize, chunks, buf))
+		}
+	}
+	return fileSize, buf, header, err
+}
+
+// Client for remote reads, with the dialer, connection limit, and connect and per-request timeouts from `opts`.
+// The global client is only used when none of those are set, so a proxy asked for is never skipped.
+// Call `release` once done with the client, which closes connections of transports that aren't shared.
+func newHTTPClient(opts Options) (client *http.Client, release func()) {
+	defaults := newOptions(nil)
+	ownTransport := opts.Dialer != nil || opts.ConnectTimeout != 0 || opts.ReadTimeout != 0 ||
+		opts.MaxConnsPerHost != defaults.MaxConnsPerHost
+	if client := GetGlobalOptions().HTTPClient; client != nil && !ownTransport {
+		return client, func() {}
+	}
+
+	transport, shared := httpTransport(opts)
+	client = &http.Client{Timeout: opts.ReadTimeout, Transport: transport}
+	if shared {
+		return client, func() {}
 	}
+	return client, transport.CloseIdleConnections
+}
+
+type transportKey struct {
+	connectTimeout  time.Duration
+	maxConnsPerHost int
+}
 
-	buf = make([]byte, totalBufferNeeded)
-	filled := 0
-	for _, span := range chunks {
-		start := span.offset
-		if start < 0 {
-			start += fileSize
+var (
+	transportsMutex sync.Mutex
+	transports      = map[transportKey]*http.Transport{}
+)
+
+// Transports are shared between reads with the same settings, so connections are reused, and the per host limit
+// applies to all concurrent reads together. Custom dialers can't be compared, so they get a transport of their own.
+func httpTransport(opts Options) (transport *http.Transport, shared bool) {
+	if opts.Dialer != nil {
+		return newHTTPTransport(opts), false
+	}
+
+	key := transportKey{opts.ConnectTimeout, opts.MaxConnsPerHost}
+	transportsMutex.Lock()
+	defer transportsMutex.Unlock()
+	transport, ok := transports[key]
+	if !ok {
+		transport = newHTTPTransport(opts)
+		transports[key] = transport
+	}
+	return transport, true
+}
+
+func newHTTPTransport(opts Options) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxConnsPerHost = max(opts.MaxConnsPerHost, 0)
+
+	dial := opts.Dialer
+	if dial == nil && opts.ConnectTimeout > 0 {
+		dial = (&net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+	} else if dial != nil && opts.ConnectTimeout > 0 {
+		custom := dial
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, opts.ConnectTimeout)
+			defer cancel()
+			return custom(ctx, network, addr)
 		}
-		err = readRemoteChunk(ctx, client, url, start, buf[filled:filled+int(span.size)])
+	}
+	if dial != nil {
+		transport.DialContext = dial
+	}
+	return transport
+}
+
+// Download the whole remote file and lay out chunks from memory, for servers that don't support ranges.
+// Only files up to `opts.RangeFallbackSizeLimit` are downloaded, bigger ones fail with `ErrRangeNotSupported`.
+func readWholeRemoteFile(ctx context.Context, client *http.Client, url string, opts Options, header http.Header, minimumRequiredSize int64, chunks ...chunkInfo) (fileSize int64, buf []byte, _ http.Header, err error) {
+	limit := opts.RangeFallbackSizeLimit
+	if limit <= 0 {
+		return 0, nil, nil, ErrRangeNotSupported
+	}
+	if contentLength, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && contentLength > limit {
+		return 0, nil, nil, ErrRangeNotSupported
+	}
+
+	start := time.Now()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, nil, nil, fmt.Errorf("couldn't download file: %s", res.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if int64(len(data)) > limit {
+		return 0, nil, nil, ErrRangeNotSupported
+	}
+	// Library code doesn't print, as the output of commands is read by the mpv script.
+	if opts.RangeLog != nil {
+		writeRangeLogEntry(opts.RangeLog, rangeLogEntry{
+			URL:        url,
+			RangeStart: 0,
+			RangeEnd:   int64(len(data)) - 1,
+			Ms:         time.Since(start).Milliseconds(),
+			Warning:    "server doesn't support range requests, downloaded the whole file to hash it",
+		})
+	}
+
+	fileSize = int64(len(data))
+	if fileSize < minimumRequiredSize {
+		return 0, nil, nil, errors.New("file is too small to generate a valid hash")
+	}
+
+	buf, err = fillChunks(opts.buffer, fileSize, chunks, func(offset int64, chunk []byte) error {
+		if offset < 0 || offset+int64(len(chunk)) > fileSize {
+			return fmt.Errorf("invalid read at %v", offset)
+		}
+		copy(chunk, data[offset:])
+		return nil
+	})
+	return fileSize, buf, res.Header, err
+}
+
+func readChunks(filePath string, opts Options, minimumRequiredSize int64, chunks ...chunkInfo) (fileSize int64, buf []byte, err error) {
+	if factory, ok := lookupURLScheme(filePath); ok {
+		reader, err := factory(filePath, opts)
 		if err != nil {
-			return
+			return 0, nil, err
 		}
-		filled += int(span.size)
+		return readReaderChunks(reader, filePath, opts, minimumRequiredSize, chunks...)
+	}
+
+	if strings.HasPrefix(filePath, "data:") {
+		data, err := decodeDataURI(filePath)
+		if err != nil {
+			return 0, nil, err
+		}
+		if int64(len(data)) < minimumRequiredSize {
+			return 0, nil, ErrDataURITooSmall
+		}
+		return readReaderChunks(bytesChunkReader(data), "", opts, minimumRequiredSize, chunks...)
+	}
+
+	// Not converted to http:// here, as only some servers take MMS over HTTP, and those that don't would be hashed
+	// wrongly from whatever they respond with.
+	if rest, ok := strings.CutPrefix(filePath, "mms://"); ok {
+		return 0, nil, fmt.Errorf("%w, try http://%s if the server streams over HTTP", ErrMMSNotSupported, rest)
 	}
-	return fileSize, buf, nil
-}
 
-func readChunks(filePath string, minimumRequiredSize int64, chunks ...chunkInfo) (fileSize int64, buf []byte, err error) {
 	if strings.HasPrefix(filePath, "http://") || strings.HasPrefix(filePath, "https://") {
-		fileSize, buf, err = readRemoteChunks(filePath, OSDBChunkSize, chunks...)
+		fileSize, buf, _, err = readRemoteChunks(filePath, opts, minimumRequiredSize, chunks...)
 		return
 	}
 
@@ -108,6 +357,14 @@ func readChunks(filePath string, minimumRequiredSize int64, chunks ...chunkInfo)
 		err = errors.New("couldn't open file for hashing")
 		return
 	}
+	defer file.Close()
+
+	if opts.FileLockTimeout > 0 {
+		if err = lockFileShared(optionsContext(opts), file, opts.FileLockTimeout); err != nil {
+			return
+		}
+		defer unlockFile(file)
+	}
 
 	fi, err := file.Stat()
 	if err != nil {
@@ -121,77 +378,336 @@ func readChunks(filePath string, minimumRequiredSize int64, chunks ...chunkInfo)
 		return
 	}
 
-	totalBufferNeeded := int64(0)
-	for _, span := range chunks {
-		totalBufferNeeded += span.size
+	fill := fillChunks
+	if opts.DeterministicOrder {
+		fill = fillChunksInOrder
 	}
+	buf, err = fill(opts.buffer, fileSize, chunks, recordReads(opts, filePath, cancellableReads(optionsContext(opts), func(offset int64, chunk []byte) error {
+		return readChunk(file, offset, chunk)
+	})))
+	return fileSize, buf, err
+}
 
-	buf = make([]byte, totalBufferNeeded)
-	filled := 0
-	for _, span := range chunks {
-		start := span.offset
-		if start < 0 {
-			start += fileSize
-		}
-		err = readChunk(file, start, buf[filled:filled+int(span.size)])
-		if err != nil {
-			return
+type HashResult struct {
+	Hash     string `json:"hash"`
+	FileSize int64  `json:"file_size"`
+	// Hash was stored rather than computed now, or its chunks were, such as with `WithETagCache()`.
+	FromCache bool          `json:"from_cache"`
+	Duration  time.Duration `json:"duration"`
+	// Modification time of the file when it was hashed, only set by `OSDBHashMiddleware()` for its cache.
+	ModTime time.Time `json:"mod_time"`
+	// Hash isn't computed yet, see `OSDBHashFileSpeculative()`.
+	Partial bool `json:"partial,omitempty"`
+	// Set when the result is delivered asynchronously, such as by `HashQueue`.
+	Err error `json:"-"`
+}
+
+// Generate an OSDB hash for a file.
+// Files with an extension registered in `FileTypes` are hashed with its chunk and minimum sizes.
+func OSDBHashFile(filePath string, opts ...Option) (hash string, err error) {
+	result, err := OSDBHashFileResult(filePath, opts...)
+	return result.Hash, err
+}
+
+// Generate an OSDB hash for a file, along with information about how it was computed.
+func OSDBHashFileResult(filePath string, opts ...Option) (HashResult, error) {
+	options := newOptions(opts)
+	fromCache := false
+	options.fromCache = &fromCache
+	start := time.Now()
+	chunkSize, minimumRequiredSize := int64(OSDBChunkSize), int64(OSDBChunkSize)
+	if spec, ok := FileTypes.Lookup(filePath); ok && spec.ChunkSize > 0 {
+		chunkSize, minimumRequiredSize = spec.ChunkSize, spec.MinSize
+	}
+	hash, fileSize, err := hashHeadAndTail(filePath, options, chunkSize, minimumRequiredSize)
+	if options.AuditLog != "" {
+		if auditErr := writeAuditLog(options, filePath, hash, time.Since(start), err); auditErr != nil && err == nil {
+			return HashResult{}, auditErr
 		}
-		filled += int(span.size)
 	}
+	if err != nil {
+		return HashResult{}, err
+	}
+	return HashResult{Hash: hash, FileSize: fileSize, FromCache: fromCache, Duration: time.Since(start)}, nil
+}
+
+// Check whether a file's OSDB hash matches `hash`, ignoring case.
+// Comparison runs in constant time, as some services hand out hashes as access tokens.
+func OSDBVerifyFile(filePath, hash string, opts ...Option) (bool, error) {
+	if _, err := decodeOSDBHash(hash); err != nil {
+		return false, err
+	}
+	actual, err := OSDBHashFile(filePath, opts...)
+	if err != nil {
+		return false, err
+	}
+	return OSDBHashEqual(actual, hash)
+}
 
-	return fileSize, buf, nil
+// Compare two OSDB hashes by their decoded bytes, ignoring case, failing with `ErrInvalidHash` if either one isn't
+// 16 hex characters. Comparison runs in constant time.
+func OSDBHashEqual(a, b string) (bool, error) {
+	aBytes, err := decodeOSDBHash(a)
+	if err != nil {
+		return false, err
+	}
+	bBytes, err := decodeOSDBHash(b)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(aBytes, bBytes) == 1, nil
 }
 
-// Generate an OSDB hash for a file.
-func OSDBHashFile(filePath string) (hash string, err error) {
-	var buf []byte
-	fileSize := int64(0)
+func decodeOSDBHash(hash string) ([]byte, error) {
+	if len(hash) != 16 {
+		return nil, ErrInvalidHash
+	}
+	decoded, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, ErrInvalidHash
+	}
+	return decoded, nil
+}
+
+// Lowercase an OSDB hash, as some subtitle APIs return them uppercase while `OSDBHashFile()` always returns
+// lowercase. Pass hashes from elsewhere through this before storing or comparing them.
+func NormaliseOSDBHash(hash string) (string, error) {
+	if _, err := decodeOSDBHash(hash); err != nil {
+		return "", err
+	}
+	return strings.ToLower(hash), nil
+}
+
+const AudioChunkSize = 32768 // 32k
+
+// Generate a hash of an audio file with the OSDB algorithm over 32k chunks (OpenAudible convention).
+// Hash is prefixed with `audio:` so it can't be mistaken for a video hash in manifests.
+func OSDBHashAudio(filePath string, opts ...Option) (hash string, err error) {
+	hash, _, err = hashHeadAndTail(filePath, newOptions(opts), AudioChunkSize, AudioChunkSize)
+	if err != nil {
+		return "", err
+	}
+	return "audio:" + hash, nil
+}
+
+// Generate an OSDB hash of a subtitle file, for use as a deduplication key by subtitle databases.
+// Subtitles are often smaller than the 64k the OSDB algorithm needs, so files down to 64 bytes are accepted, and
+// files under `2*OSDBChunkSize` are padded with zeros to that size before hashing. Their real size goes in the hash.
+func OSDBHashSubtitle(filePath string) (string, error) {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return "", errors.New("couldn't stat file for hashing")
+	}
+	if fi.Size() >= 2*OSDBChunkSize {
+		hash, _, err := hashHeadAndTail(filePath, newOptions(nil), OSDBChunkSize, OSDBChunkSize)
+		return hash, err
+	}
+	if fi.Size() < 64 {
+		return "", errors.New("file is too small to generate a valid hash")
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", errors.New("couldn't open file for hashing")
+	}
+	defer file.Close()
+
+	buf := make([]byte, 2*OSDBChunkSize)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return osdbHash(int64(n), buf), nil
+}
+
+// Sum the first and last `chunkSize` bytes of a file with its size.
+// `minimumRequiredSize` has to be at least `chunkSize`.
+func hashHeadAndTail(filePath string, opts Options, chunkSize, minimumRequiredSize int64) (hash string, fileSize int64, err error) {
+	spans := []chunkInfo{
+		{0, chunkSize},
+		{-chunkSize, chunkSize},
+	}
+
+	fileSize, buf, err := readChunks(filePath, opts, minimumRequiredSize, spans...)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return osdbHash(fileSize, buf), fileSize, nil
+}
+
+// Generate an OSDB hash for a file, reading chunks into `buf` instead of allocating a new buffer.
+// `buf` has to be at least `2*OSDBChunkSize` bytes long, and can be reused between calls.
+func OSDBHashInto(filePath string, buf []byte, opts ...Option) (hash string, err error) {
+	if len(buf) < 2*OSDBChunkSize {
+		return "", ErrBufferTooSmall
+	}
 
 	spans := []chunkInfo{
 		{0, OSDBChunkSize},
 		{-OSDBChunkSize, OSDBChunkSize},
 	}
 
-	fileSize, buf, err = readChunks(filePath, OSDBChunkSize, spans...)
-
+	options := newOptions(opts)
+	options.buffer = buf
+	fileSize, buf, err := readChunks(filePath, options, OSDBChunkSize, spans...)
 	if err != nil {
 		return "", err
 	}
 
-	// Convert to uint64, and sum
-	var nums [(OSDBChunkSize * 2) / 8]uint64
-	reader := bytes.NewReader(buf)
-	err = binary.Read(reader, binary.LittleEndian, &nums)
+	return osdbHash(fileSize, buf), nil
+}
+
+// Generate an OSDB hash for the `[start, end)` byte range of a file, as if the range was a standalone file.
+func OSDBHashFileRange(filePath string, start, end int64, opts ...Option) (hash string, err error) {
+	rangeSize := end - start
+	if start < 0 || rangeSize < OSDBChunkSize {
+		return "", errors.New("range is too small to generate a valid hash")
+	}
+
+	spans := []chunkInfo{
+		{start, OSDBChunkSize},
+		{end - OSDBChunkSize, OSDBChunkSize},
+	}
+
+	fileSize, buf, err := readChunks(filePath, newOptions(opts), end, spans...)
 	if err != nil {
 		return "", err
 	}
-	var hashUint uint64
-	for _, num := range nums {
-		hashUint += num
+	if fileSize < end {
+		return "", errors.New("range is out of file bounds")
+	}
+
+	return osdbHash(rangeSize, buf), nil
+}
+
+// Generate an OSDB hash for a remote file, and resolve its name from `Content-Disposition` header,
+// falling back to the last URL path segment.
+func OSDBHashFileURL(url string, opts ...Option) (hash, filename string, fileSize int64, err error) {
+	spans := []chunkInfo{
+		{0, OSDBChunkSize},
+		{-OSDBChunkSize, OSDBChunkSize},
+	}
+
+	fileSize, buf, header, err := readRemoteChunks(url, newOptions(opts), OSDBChunkSize, spans...)
+	if err != nil {
+		return "", "", 0, err
 	}
 
-	hashUint = hashUint + uint64(fileSize)
+	return osdbHash(fileSize, buf), remoteFilename(url, header), fileSize, nil
+}
+
+func remoteFilename(rawURL string, header http.Header) string {
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return path.Base(params["filename"])
+	}
 
-	return fmt.Sprintf("%016x", hashUint), nil
+	parsed, err := neturl.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	if filename := path.Base(parsed.Path); filename != "/" && filename != "." {
+		return filename
+	}
+	return ""
 }
 
-func readRemoteChunk(ctx context.Context, client *http.Client, url string, offset int64, buf []byte) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+// Normalise an absolute URL, lower casing its host, dropping default ports, and collapsing duplicate slashes in the
+// path, as some servers respond with 404 to `http://host//path//file.mkv`. Slashes of URLs embedded in the path, as
+// in `https://web.archive.org/web/2020/https://example.com/a.mkv`, are kept, and so is the rest of the path.
+func CanonicaliseURL(rawURL string) (string, error) {
+	parsed, err := neturl.Parse(rawURL)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if parsed.Scheme == "" || parsed.Host == "" {
+		return "", fmt.Errorf("URL \"%s\" is not absolute", rawURL)
 	}
 
-	range_header := "bytes=" + strconv.Itoa(int(offset)) + "-" + strconv.Itoa(int(offset)+len(buf)-1)
-	req.Header.Add("Range", range_header)
+	parsed.Host = strings.ToLower(parsed.Host)
+	if port := parsed.Port(); (parsed.Scheme == "http" && port == "80") || (parsed.Scheme == "https" && port == "443") {
+		parsed.Host = strings.TrimSuffix(parsed.Host, ":"+port)
+	}
 
-	resp, err := client.Do(req)
+	if escapedPath := parsed.EscapedPath(); strings.Contains(escapedPath, "//") {
+		escapedPath = collapseSlashes(escapedPath)
+		unescapedPath, err := neturl.PathUnescape(escapedPath)
+		if err != nil {
+			return "", err
+		}
+		parsed.Path, parsed.RawPath = unescapedPath, escapedPath
+	}
+
+	return parsed.String(), nil
+}
+
+// Collapse runs of slashes, except the `//` after a `scheme:`.
+func collapseSlashes(urlPath string) string {
+	collapsed := make([]byte, 0, len(urlPath))
+	for i := 0; i < len(urlPath); i++ {
+		n := len(collapsed)
+		if urlPath[i] == '/' && n > 0 && collapsed[n-1] == '/' && !(n > 1 && collapsed[n-2] == ':') {
+			continue
+		}
+		collapsed = append(collapsed, urlPath[i])
+	}
+	return string(collapsed)
+}
+
+// Sum `buf` as little endian uint64s, and add `fileSize` to the result.
+func osdbHash(fileSize int64, buf []byte) string {
+	return formatOSDBHash(sumChunk(buf) + uint64(fileSize))
+}
+
+func sumChunk(buf []byte) (sum uint64) {
+	// Convert to uint64, and sum
+	for i := 0; i+8 <= len(buf); i += 8 {
+		sum += binary.LittleEndian.Uint64(buf[i:])
+	}
+	return sum
+}
+
+func formatOSDBHash(hashUint uint64) string {
+	return fmt.Sprintf("%016x", hashUint)
+}
+
+type rangeLogEntry struct {
+	URL        string `json:"url"`
+	RangeStart int64  `json:"range_start"`
+	RangeEnd   int64  `json:"range_end"`
+	Ms         int64  `json:"ms"`
+	Error      string `json:"error,omitempty"`
+	Warning    string `json:"warning,omitempty"`
+}
+
+var rangeLogMutex sync.Mutex
+
+func logRange(w io.Writer, url string, offset int64, chunk []byte, duration time.Duration, err error) {
+	entry := rangeLogEntry{
+		URL:        url,
+		RangeStart: offset,
+		RangeEnd:   offset + int64(len(chunk)) - 1,
+		Ms:         duration.Milliseconds(),
+	}
 	if err != nil {
-		return err
+		entry.Error = err.Error()
 	}
-	defer resp.Body.Close()
+	writeRangeLogEntry(w, entry)
+}
+
+func writeRangeLogEntry(w io.Writer, entry rangeLogEntry) {
+	line, _ := JSONMarshal(entry)
+
+	rangeLogMutex.Lock()
+	defer rangeLogMutex.Unlock()
+	w.Write(line)
+}
+
+func readRemoteChunk(ctx context.Context, client *http.Client, url string, offset int64, buf []byte, maxRetries int) error {
+	reader := newRewindableReader(ctx, client, url, offset, int64(len(buf)), maxRetries)
+	defer reader.Close()
 
-	n, err := io.ReadFull(resp.Body, buf)
+	n, err := io.ReadFull(reader, buf)
 	if err != nil {
 		return err
 	}
@@ -207,7 +723,7 @@ func readChunk(file *os.File, offset int64, buf []byte) (err error) {
 	if err != nil {
 		return err
 	}
-	if n != OSDBChunkSize {
+	if n != len(buf) {
 		return fmt.Errorf("invalid read %v", n)
 	}
 	return
@@ -0,0 +1,255 @@
+package lib
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"uosc/bins/src/ziggy/lib/testutil"
+)
+
+func TestOSDBHashFile(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+	}{
+		{"one chunk", OSDBChunkSize},
+		{"head and tail touch", 2 * OSDBChunkSize},
+		{"head and tail overlap", OSDBChunkSize + 1000},
+		{"odd size", 1<<20 + 3},
+		{"large", 6 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := testutil.CreateSyntheticVideoFile(t, tt.size)
+			hash, err := OSDBHashFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := testutil.KnownHash(tt.size); hash != expected {
+				t.Errorf("hash is %s, expected %s", hash, expected)
+			}
+		})
+	}
+}
+
+func TestOSDBHashFileTooSmall(t *testing.T) {
+	path := testutil.CreateSyntheticVideoFile(t, OSDBChunkSize-1)
+	if hash, err := OSDBHashFile(path); err == nil {
+		t.Errorf("hashing a file smaller than a chunk succeeded with %s", hash)
+	}
+}
+
+func TestOSDBHashFileURL(t *testing.T) {
+	for _, size := range []int64{OSDBChunkSize, 3 << 20} {
+		data := readSyntheticFile(t, size)
+		url := testutil.StartRangeServer(t, data)
+
+		hash, filename, fileSize, err := OSDBHashFileURL(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := testutil.KnownHash(size); hash != expected {
+			t.Errorf("hash of %d bytes is %s, expected %s", size, hash, expected)
+		}
+		if filename != "file" {
+			t.Errorf("filename is %q, expected \"file\"", filename)
+		}
+		if fileSize != size {
+			t.Errorf("size is %d, expected %d", fileSize, size)
+		}
+	}
+}
+
+func TestOSDBHashInto(t *testing.T) {
+	const size = 1 << 20
+	path := testutil.CreateSyntheticVideoFile(t, size)
+	buf := make([]byte, 2*OSDBChunkSize)
+	for i := 0; i < 2; i++ {
+		hash, err := OSDBHashInto(path, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := testutil.KnownHash(size); hash != expected {
+			t.Errorf("hash is %s, expected %s", hash, expected)
+		}
+	}
+
+	allocs := testing.AllocsPerRun(10, func() { OSDBHashInto(path, buf) })
+	withoutBuffer := testing.AllocsPerRun(10, func() { OSDBHashFile(path) })
+	if allocs >= withoutBuffer {
+		t.Errorf("%v allocations with a buffer, and %v without", allocs, withoutBuffer)
+	}
+
+	if _, err := OSDBHashInto(path, buf[:2*OSDBChunkSize-1]); !errors.Is(err, ErrBufferTooSmall) {
+		t.Errorf("error is %v, expected ErrBufferTooSmall", err)
+	}
+}
+
+func TestOSDBHashFileRange(t *testing.T) {
+	const size = 1 << 20
+	path := testutil.CreateSyntheticVideoFile(t, size)
+	data := readSyntheticFile(t, size)
+
+	tests := []struct {
+		name       string
+		start, end int64
+		wantErr    bool
+	}{
+		{"whole file", 0, size, false},
+		{"middle", 1000, 1000 + 3*OSDBChunkSize, false},
+		{"exactly one chunk", 5000, 5000 + OSDBChunkSize, false},
+		{"too small", 0, OSDBChunkSize - 1, true},
+		{"negative start", -1, OSDBChunkSize, true},
+		{"past the end", size - OSDBChunkSize, size + 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := OSDBHashFileRange(path, tt.start, tt.end)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("succeeded with %s", hash)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := OSDBHashBytes(data[tt.start:tt.end])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != expected {
+				t.Errorf("hash is %s, expected %s", hash, expected)
+			}
+		})
+	}
+}
+
+func TestCanonicaliseURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+		wantErr  bool
+	}{
+		{url: "http://host/path/file.mkv", expected: "http://host/path/file.mkv"},
+		{url: "http://host//path//file.mkv", expected: "http://host/path/file.mkv"},
+		{url: "http://host/path///file.mkv", expected: "http://host/path/file.mkv"},
+		// Trailing slashes, dots, and queries are the server's business.
+		{url: "http://host/dir/", expected: "http://host/dir/"},
+		{url: "http://host/dir//", expected: "http://host/dir/"},
+		{url: "http://host/a/../b/./c.mkv", expected: "http://host/a/../b/./c.mkv"},
+		{url: "http://host/file.mkv?a=1&b=//x", expected: "http://host/file.mkv?a=1&b=//x"},
+		{url: "HTTP://Example.COM/File.mkv", expected: "http://example.com/File.mkv"},
+		{url: "http://host:80/file.mkv", expected: "http://host/file.mkv"},
+		{url: "https://host:443/file.mkv", expected: "https://host/file.mkv"},
+		{url: "http://host:443/file.mkv", expected: "http://host:443/file.mkv"},
+		{url: "https://host:8443/file.mkv", expected: "https://host:8443/file.mkv"},
+		{
+			url:      "https://web.archive.org/web/2020/https://example.com/a.mkv",
+			expected: "https://web.archive.org/web/2020/https://example.com/a.mkv",
+		},
+		{
+			url:      "https://web.archive.org//web/2020/https://example.com//a.mkv",
+			expected: "https://web.archive.org/web/2020/https://example.com/a.mkv",
+		},
+		{url: "http://host//a%2F%2Fb.mkv", expected: "http://host/a%2F%2Fb.mkv"},
+		{url: "http://host//file%20name.mkv", expected: "http://host/file%20name.mkv"},
+		{url: "/relative/file.mkv", wantErr: true},
+		{url: "file.mkv", wantErr: true},
+		{url: "http://host/%zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			canonical, err := CanonicaliseURL(tt.url)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("succeeded with %s", canonical)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if canonical != tt.expected {
+				t.Errorf("got %s, expected %s", canonical, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormaliseOSDBHash(t *testing.T) {
+	tests := []struct {
+		hash     string
+		expected string
+		wantErr  bool
+	}{
+		{hash: "8e245d9679d31e12", expected: "8e245d9679d31e12"},
+		{hash: "8E245D9679D31E12", expected: "8e245d9679d31e12"},
+		{hash: "", wantErr: true},
+		{hash: "abcd", wantErr: true},
+		{hash: "0x8E245D9679D31E12", wantErr: true},
+		{hash: "8e245d9679d31e1g", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hash, func(t *testing.T) {
+			normalised, err := NormaliseOSDBHash(tt.hash)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidHash) {
+					t.Errorf("error is %v, expected ErrInvalidHash", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if normalised != tt.expected {
+				t.Errorf("got %s, expected %s", normalised, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOSDBHashEqual(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		equal   bool
+		wantErr bool
+	}{
+		{a: "8e245d9679d31e12", b: "8e245d9679d31e12", equal: true},
+		{a: "8e245d9679d31e12", b: "8E245D9679D31E12", equal: true},
+		{a: "8e245d9679d31e12", b: "8e245d9679d31e13", equal: false},
+		{a: "8e245d9679d31e12", b: "", wantErr: true},
+		{a: "8e245d9679d31e12", b: "0x8e245d9679d31e12", wantErr: true},
+		{a: "000000000000abcd", b: "abcd", wantErr: true},
+		{a: "8e245d9679d31e12", b: "8e245d9679d31e1200", wantErr: true},
+		{a: "8e245d9679d31e1g", b: "8e245d9679d31e12", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"="+tt.b, func(t *testing.T) {
+			equal, err := OSDBHashEqual(tt.a, tt.b)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidHash) {
+					t.Errorf("error is %v, expected ErrInvalidHash", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if equal != tt.equal {
+				t.Errorf("equal is %v, expected %v", equal, tt.equal)
+			}
+		})
+	}
+}
+
+// Contents of the file `testutil.CreateSyntheticVideoFile()` creates for `size`.
+func readSyntheticFile(tb testing.TB, size int64) []byte {
+	tb.Helper()
+	data, err := os.ReadFile(testutil.CreateSyntheticVideoFile(tb, size))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return data
+}
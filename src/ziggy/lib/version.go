@@ -0,0 +1,27 @@
+package lib
+
+import "runtime"
+
+const Version = "0.1.0"
+
+// Set at link time by `tools/build`, with `-ldflags "-X uosc/bins/src/ziggy/lib.gitCommit=..."`.
+var (
+	gitCommit = "unknown"
+	buildDate = "unknown"
+)
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	GitCommit string `json:"git_commit"`
+	BuildDate string `json:"build_date"`
+}
+
+func GetBuildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		GoVersion: runtime.Version(),
+		GitCommit: gitCommit,
+		BuildDate: buildDate,
+	}
+}
@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+var ErrNotVideoFile = errors.New("file content is not a known video container")
+
+// How much of a file's head is needed to recognize its container.
+const sniffSize = 3 * MPEGTSPacketSize
+
+// Check whether file content starts like a known video container, regardless of its extension. Remote files are
+// also videos when their server says so with a video `Content-Type`, for containers that can't be recognized.
+func IsVideoFile(filePath string, opts ...Option) (bool, error) {
+	if _, registered := lookupURLScheme(filePath); !registered &&
+		(strings.HasPrefix(filePath, "http://") || strings.HasPrefix(filePath, "https://")) {
+		_, head, header, err := readRemoteChunks(filePath, newOptions(opts), sniffSize, chunkInfo{0, sniffSize})
+		if err != nil {
+			return false, err
+		}
+		return isVideoHeader(head) || isVideoContentType(header), nil
+	}
+
+	_, head, err := readChunks(filePath, newOptions(opts), sniffSize, chunkInfo{0, sniffSize})
+	if err != nil {
+		return false, err
+	}
+	return isVideoHeader(head), nil
+}
+
+// Generate an OSDB hash for a file, but only if `IsVideoFile()` says its content is a known video container, so
+// that subtitles or other files that ended up in the input aren't hashed by accident.
+func OSDBHashFileStrict(filePath string, opts ...Option) (string, error) {
+	isVideo, err := IsVideoFile(filePath, opts...)
+	if err != nil {
+		return "", err
+	}
+	if !isVideo {
+		return "", ErrNotVideoFile
+	}
+	return OSDBHashFile(filePath, opts...)
+}
+
+// Audio-only brands of ISO base media files.
+var audioMP4Brands = [][]byte{[]byte("M4A "), []byte("M4B "), []byte("M4P "), []byte("F4A "), []byte("F4B ")}
+
+func isVideoHeader(head []byte) bool {
+	if len(head) < 12 {
+		return false
+	}
+
+	switch {
+	// Matroska & WebM
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return true
+	// MP4, MOV, 3GP, ...
+	case bytes.Equal(head[4:8], []byte("ftyp")):
+		for _, brand := range audioMP4Brands {
+			if bytes.Equal(head[8:12], brand) {
+				return false
+			}
+		}
+		return true
+	// Old QuickTime files without `ftyp`
+	case bytes.Equal(head[4:8], []byte("moov")), bytes.Equal(head[4:8], []byte("mdat")), bytes.Equal(head[4:8], []byte("wide")):
+		return true
+	// AVI
+	case bytes.HasPrefix(head, []byte("RIFF")) && bytes.Equal(head[8:12], []byte("AVI ")):
+		return true
+	// ASF, WMV
+	case bytes.HasPrefix(head, []byte{0x30, 0x26, 0xB2, 0x75, 0x8E, 0x66, 0xCF, 0x11}):
+		return true
+	// MPEG program stream, and MPEG video elementary stream
+	case bytes.HasPrefix(head, []byte{0x00, 0x00, 0x01, 0xBA}), bytes.HasPrefix(head, []byte{0x00, 0x00, 0x01, 0xB3}):
+		return true
+	// FLV, and RealMedia
+	case bytes.HasPrefix(head, []byte("FLV")), bytes.HasPrefix(head, []byte(".RMF")):
+		return true
+	// Ogg, only when it carries a theora stream
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return bytes.Contains(head[:min(len(head), 128)], []byte("\x80theora"))
+	// MPEG transport stream, sync byte has to repeat every packet
+	case len(head) >= sniffSize && head[0] == 0x47 && head[MPEGTSPacketSize] == 0x47 && head[2*MPEGTSPacketSize] == 0x47:
+		return true
+	}
+	return false
+}
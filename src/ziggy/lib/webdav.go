@@ -0,0 +1,258 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type webDAVChunkReader struct {
+	url        string
+	user, pass string
+	client     *http.Client
+	timeout    time.Duration
+
+	mutex sync.Mutex
+	// Scheme the server asked for once it responded with 401, empty until then.
+	authScheme string
+	digest     map[string]string
+	nonceCount int
+}
+
+// Read a file on a WebDAV share, with its size from `PROPFIND` and chunks fetched with ranged `GET` requests.
+// Credentials are only sent once the server asks for them, with Basic or Digest auth, whichever it asks for.
+func NewWebDAVChunkReader(webdavURL, user, pass string) ChunkReader {
+	return &webDAVChunkReader{
+		url:     webdavURL,
+		user:    user,
+		pass:    pass,
+		client:  &http.Client{},
+		timeout: newOptions(nil).Timeout,
+	}
+}
+
+const webDAVPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><getcontentlength/></prop></propfind>`
+
+type webDAVMultistatus struct {
+	Responses []struct {
+		Propstats []struct {
+			Status        string `xml:"status"`
+			ContentLength string `xml:"prop>getcontentlength"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+func (r *webDAVChunkReader) Size() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	header := http.Header{"Depth": {"0"}, "Content-Type": {"application/xml; charset=utf-8"}}
+	res, err := r.do(ctx, "PROPFIND", header, []byte(webDAVPropfindBody))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusMultiStatus {
+		return 0, fmt.Errorf("WebDAV PROPFIND failed: %s", res.Status)
+	}
+
+	var multistatus webDAVMultistatus
+	if err := xml.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&multistatus); err != nil {
+		return 0, fmt.Errorf("couldn't parse WebDAV PROPFIND response: %w", err)
+	}
+	for _, response := range multistatus.Responses {
+		for _, propstat := range response.Propstats {
+			if propstat.ContentLength != "" && strings.Contains(propstat.Status, " 200 ") {
+				return strconv.ParseInt(strings.TrimSpace(propstat.ContentLength), 10, 64)
+			}
+		}
+	}
+	return 0, errors.New("WebDAV server didn't report file size")
+}
+
+func (r *webDAVChunkReader) ReadChunk(offset int64, buf []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", offset, offset+int64(len(buf))-1)}}
+	res, err := r.do(ctx, "GET", header, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("WebDAV ranged GET failed: %s", res.Status)
+	}
+	_, err = io.ReadFull(res.Body, buf)
+	return err
+}
+
+// Send a request, authenticating and retrying once when the server responds with 401.
+func (r *webDAVChunkReader) do(ctx context.Context, method string, header http.Header, body []byte) (*http.Response, error) {
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, r.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if err := r.authorize(req); err != nil {
+			return nil, err
+		}
+		return r.client.Do(req)
+	}
+
+	res, err := send()
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	res.Body.Close()
+
+	if err := r.challenge(res.Header.Values("WWW-Authenticate")); err != nil {
+		return nil, err
+	}
+	return send()
+}
+
+// Remember the strongest auth scheme the server offers.
+func (r *webDAVChunkReader) challenge(challenges []string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	basic := false
+	for _, challenge := range challenges {
+		scheme, params, _ := strings.Cut(challenge, " ")
+		switch strings.ToLower(scheme) {
+		case "digest":
+			r.authScheme, r.digest, r.nonceCount = "digest", parseAuthParams(params), 0
+			return nil
+		case "basic":
+			basic = true
+		}
+	}
+	if basic {
+		r.authScheme = "basic"
+		return nil
+	}
+	return errors.New("WebDAV server asked for an unsupported auth scheme")
+}
+
+func (r *webDAVChunkReader) authorize(req *http.Request) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch r.authScheme {
+	case "basic":
+		req.SetBasicAuth(r.user, r.pass)
+	case "digest":
+		var h func() hash.Hash
+		switch algorithm := strings.ToUpper(r.digest["algorithm"]); algorithm {
+		case "", "MD5":
+			h = md5.New
+		case "SHA-256":
+			h = sha256.New
+		default:
+			return fmt.Errorf("unsupported digest algorithm %s", algorithm)
+		}
+		sum := func(parts ...string) string {
+			digest := h()
+			io.WriteString(digest, strings.Join(parts, ":"))
+			return hex.EncodeToString(digest.Sum(nil))
+		}
+
+		uri := req.URL.RequestURI()
+		realm, nonce := r.digest["realm"], r.digest["nonce"]
+		ha1 := sum(r.user, realm, r.pass)
+		ha2 := sum(req.Method, uri)
+
+		fields := []string{
+			fmt.Sprintf(`username="%s"`, r.user),
+			fmt.Sprintf(`realm="%s"`, realm),
+			fmt.Sprintf(`nonce="%s"`, nonce),
+			fmt.Sprintf(`uri="%s"`, uri),
+		}
+		if qops := strings.Split(r.digest["qop"], ","); r.digest["qop"] != "" {
+			if !containsToken(qops, "auth") {
+				return errors.New("WebDAV server asked for an unsupported digest qop")
+			}
+			r.nonceCount++
+			nc := fmt.Sprintf("%08x", r.nonceCount)
+			var nonceBytes [8]byte
+			rand.Read(nonceBytes[:])
+			cnonce := hex.EncodeToString(nonceBytes[:])
+			fields = append(fields,
+				"qop=auth",
+				"nc="+nc,
+				fmt.Sprintf(`cnonce="%s"`, cnonce),
+				fmt.Sprintf(`response="%s"`, sum(ha1, nonce, nc, cnonce, "auth", ha2)),
+			)
+		} else {
+			fields = append(fields, fmt.Sprintf(`response="%s"`, sum(ha1, nonce, ha2)))
+		}
+		if algorithm := r.digest["algorithm"]; algorithm != "" {
+			fields = append(fields, "algorithm="+algorithm)
+		}
+		if opaque, ok := r.digest["opaque"]; ok {
+			fields = append(fields, fmt.Sprintf(`opaque="%s"`, opaque))
+		}
+		req.Header.Set("Authorization", "Digest "+strings.Join(fields, ", "))
+	}
+	return nil
+}
+
+func containsToken(tokens []string, token string) bool {
+	for _, t := range tokens {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse `key=value, key="quoted, value"` auth challenge parameters.
+func parseAuthParams(params string) map[string]string {
+	result := map[string]string{}
+	for params = strings.TrimSpace(params); params != ""; {
+		key, rest, found := strings.Cut(params, "=")
+		if !found {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		rest = strings.TrimSpace(rest)
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			value = strings.ReplaceAll(rest[1:min(end, len(rest))], `\`, "")
+			rest = rest[min(end+1, len(rest)):]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+			rest = "," + rest
+		}
+		result[key] = value
+		params = strings.TrimLeft(rest, ", ")
+	}
+	return result
+}
@@ -0,0 +1,185 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"uosc/bins/src/ziggy/lib/testutil"
+)
+
+const webDAVTestUser, webDAVTestPass = "alice", "s3cret"
+
+// Serve `data` as a WebDAV file, behind the auth scheme `challenge` asks for, or none when it's empty.
+func startWebDAVServer(tb testing.TB, data []byte, challenge string, multistatus string) string {
+	tb.Helper()
+	params := parseAuthParams(strings.TrimPrefix(challenge, "Digest "))
+	modTime := time.Now()
+
+	authorized := func(r *http.Request) bool {
+		auth := r.Header.Get("Authorization")
+		switch {
+		case challenge == "":
+			return true
+		case strings.HasPrefix(challenge, "Basic "):
+			user, pass, ok := r.BasicAuth()
+			return ok && user == webDAVTestUser && pass == webDAVTestPass
+		case strings.HasPrefix(challenge, "Digest ") && strings.HasPrefix(auth, "Digest "):
+			fields := parseAuthParams(strings.TrimPrefix(auth, "Digest "))
+			h := md5.New
+			if params["algorithm"] == "SHA-256" {
+				h = sha256.New
+			}
+			sum := func(h func() hash.Hash, parts ...string) string {
+				digest := h()
+				io.WriteString(digest, strings.Join(parts, ":"))
+				return hex.EncodeToString(digest.Sum(nil))
+			}
+			ha1 := sum(h, webDAVTestUser, params["realm"], webDAVTestPass)
+			ha2 := sum(h, r.Method, r.URL.RequestURI())
+			expected := sum(h, ha1, params["nonce"], ha2)
+			if params["qop"] != "" {
+				if fields["qop"] != "auth" || fields["nc"] == "" || fields["cnonce"] == "" {
+					return false
+				}
+				expected = sum(h, ha1, params["nonce"], fields["nc"], fields["cnonce"], "auth", ha2)
+			}
+			return fields["username"] == webDAVTestUser && fields["uri"] == r.URL.RequestURI() &&
+				fields["opaque"] == params["opaque"] && fields["response"] == expected
+		}
+		return false
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r) {
+			w.Header().Add("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case "PROPFIND":
+			if r.Header.Get("Depth") != "0" {
+				http.Error(w, "expected depth 0", http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprintf(w, multistatus, len(data))
+		case "GET":
+			http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	tb.Cleanup(server.Close)
+	return server.URL + "/share/movie.mkv"
+}
+
+const webDAVTestMultistatus = `<?xml version="1.0" encoding="utf-8"?>
+<d:multistatus xmlns:d="DAV:"><d:response><d:href>/share/movie.mkv</d:href>
+	<d:propstat><d:prop><d:getcontentlength>%d</d:getcontentlength></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>
+</d:response></d:multistatus>`
+
+func TestWebDAVChunkReader(t *testing.T) {
+	data := readSyntheticFile(t, 200000)
+
+	tests := []struct {
+		name        string
+		challenge   string
+		multistatus string
+		pass        string
+		wantErr     bool
+	}{
+		{name: "no auth"},
+		{name: "basic", challenge: `Basic realm="share"`},
+		{name: "digest", challenge: `Digest realm="share", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093"`},
+		{
+			name:      "digest with qop and opaque",
+			challenge: `Digest realm="share", qop="auth,auth-int", nonce="dcd98b7102dd2f0e", opaque="5ccc069c403ebaf9"`,
+		},
+		{
+			name:      "digest SHA-256",
+			challenge: `Digest realm="share", qop="auth", algorithm=SHA-256, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"`,
+		},
+		{
+			name: "status before size",
+			multistatus: `<?xml version="1.0"?><multistatus xmlns="DAV:"><response>
+				<propstat><prop><getetag/></prop><status>HTTP/1.1 404 Not Found</status></propstat>
+				<propstat><status>HTTP/1.1 200 OK</status><prop><getcontentlength> %d </getcontentlength></prop></propstat>
+			</response></multistatus>`,
+		},
+		{name: "wrong password", challenge: `Basic realm="share"`, pass: "wrong", wantErr: true},
+		{name: "wrong digest password", challenge: `Digest realm="share", qop="auth", nonce="abc"`, pass: "wrong", wantErr: true},
+		{name: "unsupported scheme", challenge: `Negotiate`, wantErr: true},
+		{name: "unsupported digest algorithm", challenge: `Digest realm="share", nonce="abc", algorithm=SHA-512-256`, wantErr: true},
+		{name: "unsupported digest qop", challenge: `Digest realm="share", nonce="abc", qop="auth-int"`, wantErr: true},
+		{
+			name:        "no size",
+			multistatus: `<multistatus xmlns="DAV:"><response><propstat><status>HTTP/1.1 404 Not Found</status><prop><getcontentlength>%d</getcontentlength></prop></propstat></response></multistatus>`,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.multistatus == "" {
+				tt.multistatus = webDAVTestMultistatus
+			}
+			if tt.pass == "" {
+				tt.pass = webDAVTestPass
+			}
+			url := startWebDAVServer(t, data, tt.challenge, tt.multistatus)
+			reader := NewWebDAVChunkReader(url, webDAVTestUser, tt.pass)
+
+			if tt.wantErr {
+				if size, err := reader.Size(); err == nil {
+					t.Errorf("Size() succeeded with %d", size)
+				}
+				return
+			}
+			if err := testutil.ValidateChunkReader(reader, data); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	tests := []struct {
+		params   string
+		expected map[string]string
+	}{
+		{``, map[string]string{}},
+		{`realm="share"`, map[string]string{"realm": "share"}},
+		{
+			`realm="a, b", nonce="x=y", qop="auth,auth-int"`,
+			map[string]string{"realm": "a, b", "nonce": "x=y", "qop": "auth,auth-int"},
+		},
+		{`algorithm=MD5, stale=false`, map[string]string{"algorithm": "MD5", "stale": "false"}},
+		{`Realm = "share" ,NONCE=abc`, map[string]string{"realm": "share", "nonce": "abc"}},
+		{`realm="say \"hi\"", nonce=abc`, map[string]string{"realm": `say "hi"`, "nonce": "abc"}},
+		{`realm="unterminated`, map[string]string{"realm": "unterminated"}},
+		{`realm="share", broken`, map[string]string{"realm": "share"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.params, func(t *testing.T) {
+			params := parseAuthParams(tt.params)
+			if len(params) != len(tt.expected) {
+				t.Fatalf("got %v, expected %v", params, tt.expected)
+			}
+			for key, value := range tt.expected {
+				if params[key] != value {
+					t.Errorf("got %v, expected %v", params, tt.expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"errors"
+	"os"
+	"strconv"
+)
+
+var errXattrUnsupported = errors.New("extended attributes are not supported on this platform")
+
+// Generate an OSDB hash for a file, caching it in the file's extended attributes.
+// Cached hash is only used when the file's modification time and size still match the ones it was computed for.
+// When the platform or file system doesn't support extended attributes, the hash is just computed.
+func OSDBHashFileCachedXattr(path string, opts ...Option) (hash string, fromCache bool, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", false, errors.New("couldn't stat file for hashing")
+	}
+	mtime := strconv.FormatInt(fi.ModTime().UnixNano(), 10)
+	size := strconv.FormatInt(fi.Size(), 10)
+
+	cachedHash, hashErr := getXattr(path, xattrHash)
+	cachedMtime, mtimeErr := getXattr(path, xattrMtime)
+	cachedSize, sizeErr := getXattr(path, xattrSize)
+	if hashErr == nil && mtimeErr == nil && sizeErr == nil && cachedMtime == mtime && cachedSize == size && len(cachedHash) == 16 {
+		return cachedHash, true, nil
+	}
+
+	hash, err = OSDBHashFile(path, opts...)
+	if err != nil {
+		return "", false, err
+	}
+
+	// Caching is best effort, file might be on a read-only or non-supporting file system.
+	if setXattr(path, xattrHash, hash) == nil {
+		setXattr(path, xattrMtime, mtime)
+		setXattr(path, xattrSize, size)
+	}
+
+	return hash, false, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package lib
+
+const xattrHash, xattrMtime, xattrSize = "", "", ""
+
+func getXattr(path string, name string) (string, error) {
+	return "", errXattrUnsupported
+}
+
+func setXattr(path string, name string, value string) error {
+	return errXattrUnsupported
+}
@@ -0,0 +1,30 @@
+//go:build linux || darwin
+
+package lib
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+var xattrHash, xattrMtime, xattrSize = func() (string, string, string) {
+	if runtime.GOOS == "darwin" {
+		return "com.apple.metadata:osdb_hash", "com.apple.metadata:osdb_mtime", "com.apple.metadata:osdb_size"
+	}
+	return "user.osdb.hash", "user.osdb.mtime", "user.osdb.size"
+}()
+
+func getXattr(path string, name string) (string, error) {
+	// Cached values are short, anything longer isn't ours.
+	var buf [64]byte
+	n, err := unix.Getxattr(path, name, buf[:])
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+func setXattr(path string, name string, value string) error {
+	return unix.Setxattr(path, name, []byte(value), 0)
+}
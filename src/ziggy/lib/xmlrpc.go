@@ -0,0 +1,301 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const OSDB_XMLRPC_URL = "https://api.opensubtitles.org/xml-rpc"
+
+const OSDB_API_URL = "https://api.opensubtitles.com/api/v1"
+
+// Client for the legacy Open Subtitles XML-RPC API.
+type OSDBClient struct {
+	baseURL string
+	client  *http.Client
+	// Deadline for each call, so a stalled server doesn't hang the caller.
+	timeout time.Duration
+}
+
+type SubtitleInfo struct {
+	ID           string `json:"id"`
+	FileName     string `json:"file_name"`
+	Language     string `json:"language"`
+	Format       string `json:"format"`
+	MovieName    string `json:"movie_name"`
+	DownloadLink string `json:"download_link"`
+	// Hash of the subtitle file itself, identical files share it even when uploaded under different names.
+	OSDBFileHash  string `json:"osdb_file_hash"`
+	DownloadCount int    `json:"download_count"`
+}
+
+// Keep one subtitle per `OSDBFileHash`, the one downloaded most, in the position of the first one of its group.
+// Subtitles without a hash can't be compared, so they're all kept.
+func DeduplicateSubtitles(subtitles []SubtitleInfo) []SubtitleInfo {
+	result := []SubtitleInfo{}
+	seen := map[string]int{}
+	for _, subtitle := range subtitles {
+		if subtitle.OSDBFileHash == "" {
+			result = append(result, subtitle)
+			continue
+		}
+		hash := strings.ToLower(subtitle.OSDBFileHash)
+		if i, ok := seen[hash]; ok {
+			if subtitle.DownloadCount > result[i].DownloadCount {
+				result[i] = subtitle
+			}
+			continue
+		}
+		seen[hash] = len(result)
+		result = append(result, subtitle)
+	}
+	return result
+}
+
+// URL of the `api.opensubtitles.com` search for subtitles of a file, with parameters sorted and lowercased as the
+// API requires. `language` is a comma separated list of codes such as `en,pt-br`, and `hash` is left out when empty.
+// The API only matches on the hash, so `fileSize` isn't part of the URL.
+func OpenSubtitlesSearchURL(hash string, fileSize int64, language string) string {
+	params := neturl.Values{}
+	if language != "" {
+		languages := regexp.MustCompile(" *, *").Split(strings.ToLower(strings.TrimSpace(language)), -1)
+		slices.Sort(languages)
+		params.Set("languages", strings.Join(languages, ","))
+	}
+	if hash != "" {
+		params.Set("moviehash", strings.ToLower(hash))
+	}
+	// Encode() sorts parameters by name
+	return OSDB_API_URL + "/subtitles?" + params.Encode()
+}
+
+// Query struct of the XML-RPC `SearchSubtitles` method for subtitles of a file.
+func OpenSubtitlesXMLRPCSearchParams(hash string, fileSize int64, language string) map[string]string {
+	params := map[string]string{
+		"moviehash":     strings.ToLower(hash),
+		"moviebytesize": strconv.FormatInt(fileSize, 10),
+	}
+	if language != "" {
+		params["sublanguageid"] = language
+	}
+	return params
+}
+
+// Use `OSDB_XMLRPC_URL` as `baseURL` unless talking to a mirror.
+func NewOSDBXMLRPCClient(baseURL string) *OSDBClient {
+	return &OSDBClient{baseURL: baseURL, client: &http.Client{}, timeout: newOptions(nil).Timeout}
+}
+
+func (c *OSDBClient) Login(user, pass, lang, agent string) (token string, err error) {
+	res, err := c.call("LogIn", user, pass, lang, agent)
+	if err != nil {
+		return "", err
+	}
+	token, _ = res["token"].(string)
+	if token == "" {
+		return "", errors.New("login response is missing a token")
+	}
+	return token, nil
+}
+
+func (c *OSDBClient) SearchSubtitlesByHash(token, lang, hash string, size int64) ([]SubtitleInfo, error) {
+	query := map[string]any{}
+	for key, value := range OpenSubtitlesXMLRPCSearchParams(hash, size, lang) {
+		query[key] = value
+	}
+	res, err := c.call("SearchSubtitles", token, []any{query})
+	if err != nil {
+		return nil, err
+	}
+
+	// No results are reported as `data: false`
+	items, _ := res["data"].([]any)
+	subtitles := []SubtitleInfo{}
+	for _, item := range items {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		field := func(name string) string {
+			value, _ := fields[name].(string)
+			return value
+		}
+		// Counts are sent as strings
+		downloadCount, _ := strconv.Atoi(field("SubDownloadsCnt"))
+		subtitles = append(subtitles, SubtitleInfo{
+			ID:            field("IDSubtitleFile"),
+			FileName:      field("SubFileName"),
+			Language:      field("SubLanguageID"),
+			Format:        field("SubFormat"),
+			MovieName:     field("MovieName"),
+			DownloadLink:  field("SubDownloadLink"),
+			OSDBFileHash:  field("SubHash"),
+			DownloadCount: downloadCount,
+		})
+	}
+	return subtitles, nil
+}
+
+func (c *OSDBClient) Logout(token string) error {
+	_, err := c.call("LogOut", token)
+	return err
+}
+
+// Call `method` and return its response struct, erroring on faults and non 200 statuses.
+func (c *OSDBClient) call(method string, params ...any) (map[string]any, error) {
+	body := &bytes.Buffer{}
+	body.WriteString(xml.Header)
+	body.WriteString("<methodCall><methodName>" + method + "</methodName><params>")
+	for _, param := range params {
+		body.WriteString("<param>")
+		if err := encodeXMLRPCValue(body, param); err != nil {
+			return nil, err
+		}
+		body.WriteString("</param>")
+	}
+	body.WriteString("</params></methodCall>")
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	var response struct {
+		Params []xmlrpcValue `xml:"params>param>value"`
+		Fault  *xmlrpcValue  `xml:"fault>value"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	if response.Fault != nil {
+		fault, _ := response.Fault.decode().(map[string]any)
+		return nil, fmt.Errorf("%s fault: %v", method, fault["faultString"])
+	}
+	if len(response.Params) == 0 {
+		return nil, fmt.Errorf("%s returned no value", method)
+	}
+
+	res, ok := response.Params[0].decode().(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s returned an unexpected value", method)
+	}
+	if status, _ := res["status"].(string); !strings.HasPrefix(status, "200") {
+		return nil, fmt.Errorf("%s failed: %s", method, status)
+	}
+	return res, nil
+}
+
+func encodeXMLRPCValue(buf *bytes.Buffer, value any) error {
+	buf.WriteString("<value>")
+	switch value := value.(type) {
+	case string:
+		buf.WriteString("<string>")
+		xml.EscapeText(buf, []byte(value))
+		buf.WriteString("</string>")
+	case int:
+		buf.WriteString("<int>" + strconv.Itoa(value) + "</int>")
+	case bool:
+		if value {
+			buf.WriteString("<boolean>1</boolean>")
+		} else {
+			buf.WriteString("<boolean>0</boolean>")
+		}
+	case []any:
+		buf.WriteString("<array><data>")
+		for _, item := range value {
+			if err := encodeXMLRPCValue(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("</data></array>")
+	case map[string]any:
+		buf.WriteString("<struct>")
+		for name, item := range value {
+			buf.WriteString("<member><name>")
+			xml.EscapeText(buf, []byte(name))
+			buf.WriteString("</name>")
+			if err := encodeXMLRPCValue(buf, item); err != nil {
+				return err
+			}
+			buf.WriteString("</member>")
+		}
+		buf.WriteString("</struct>")
+	default:
+		return fmt.Errorf("unsupported XML-RPC value type %T", value)
+	}
+	buf.WriteString("</value>")
+	return nil
+}
+
+type xmlrpcValue struct {
+	String  *string `xml:"string"`
+	Int     *string `xml:"int"`
+	I4      *string `xml:"i4"`
+	Double  *string `xml:"double"`
+	Boolean *string `xml:"boolean"`
+	Struct  *struct {
+		Members []struct {
+			Name  string      `xml:"name"`
+			Value xmlrpcValue `xml:"value"`
+		} `xml:"member"`
+	} `xml:"struct"`
+	Array *struct {
+		Values []xmlrpcValue `xml:"data>value"`
+	} `xml:"array"`
+	// Values without a type element are strings.
+	Text string `xml:",chardata"`
+}
+
+func (v xmlrpcValue) decode() any {
+	switch {
+	case v.String != nil:
+		return *v.String
+	case v.Int != nil:
+		n, _ := strconv.Atoi(strings.TrimSpace(*v.Int))
+		return n
+	case v.I4 != nil:
+		n, _ := strconv.Atoi(strings.TrimSpace(*v.I4))
+		return n
+	case v.Double != nil:
+		n, _ := strconv.ParseFloat(strings.TrimSpace(*v.Double), 64)
+		return n
+	case v.Boolean != nil:
+		return strings.TrimSpace(*v.Boolean) == "1"
+	case v.Struct != nil:
+		fields := map[string]any{}
+		for _, member := range v.Struct.Members {
+			fields[member.Name] = member.Value.decode()
+		}
+		return fields
+	case v.Array != nil:
+		items := []any{}
+		for _, item := range v.Array.Values {
+			items = append(items, item.decode())
+		}
+		return items
+	}
+	return v.Text
+}
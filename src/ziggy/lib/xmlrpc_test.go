@@ -0,0 +1,20 @@
+package lib
+
+import "testing"
+
+func TestOpenSubtitlesSearchURL(t *testing.T) {
+	tests := []struct {
+		hash, language string
+		expected       string
+	}{
+		{"8E245D9679D31E12", "en", OSDB_API_URL + "/subtitles?languages=en&moviehash=8e245d9679d31e12"},
+		{"8e245d9679d31e12", "pt-BR , en,fr", OSDB_API_URL + "/subtitles?languages=en%2Cfr%2Cpt-br&moviehash=8e245d9679d31e12"},
+		{"8e245d9679d31e12", "", OSDB_API_URL + "/subtitles?moviehash=8e245d9679d31e12"},
+		{"", "en", OSDB_API_URL + "/subtitles?languages=en"},
+	}
+	for _, tt := range tests {
+		if url := OpenSubtitlesSearchURL(tt.hash, 12909756, tt.language); url != tt.expected {
+			t.Errorf("URL for %q in %q is %s, expected %s", tt.hash, tt.language, url, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package lib
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var ErrWrongPassword = errors.New("wrong password for encrypted zip entry")
+
+// WinZip AES encryption, see https://www.winzip.com/en/support/aes-encryption/
+const (
+	zipMethodAES    = 99
+	zipExtraAES     = 0x9901
+	zipAESVerifier  = 2
+	zipAESAuthCode  = 10
+	zipAESIteration = 1000
+)
+
+// Generate an OSDB hash for an entry of a zip archive encrypted with WinZip AES, without extracting it to disk.
+// Entries stored without compression are hashed by decrypting only the two chunks, compressed ones are decrypted
+// and inflated as a stream. Unencrypted entries are hashed as is, ignoring `password`.
+func OSDBHashEncryptedZip(zipPath, entryName, password string) (string, error) {
+	archive, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return "", fmt.Errorf("couldn't open zip archive: %w", err)
+	}
+	defer archive.Close()
+
+	var entry *zip.File
+	for _, file := range archive.File {
+		if file.Name == entryName {
+			entry = file
+			break
+		}
+	}
+	if entry == nil {
+		return "", fmt.Errorf("zip archive has no entry \"%s\"", entryName)
+	}
+
+	fileSize := int64(entry.UncompressedSize64)
+	if fileSize < OSDBChunkSize {
+		return "", errors.New("file is too small to generate a valid hash")
+	}
+
+	if entry.Method != zipMethodAES {
+		if entry.Flags&0x1 != 0 {
+			return "", errors.New("only WinZip AES encryption is supported")
+		}
+		src, err := entry.Open()
+		if err != nil {
+			return "", err
+		}
+		defer src.Close()
+		return hashZipStream(src, fileSize)
+	}
+
+	strength, method, err := parseZipAESExtra(entry.Extra)
+	if err != nil {
+		return "", err
+	}
+	raw, err := entry.OpenRaw()
+	if err != nil {
+		return "", err
+	}
+	rawReader, ok := raw.(io.ReaderAt)
+	if !ok {
+		return "", errors.New("zip entry doesn't support random access")
+	}
+
+	saltSize := 4 + 4*int64(strength)
+	header := make([]byte, saltSize+zipAESVerifier)
+	if _, err := rawReader.ReadAt(header, 0); err != nil {
+		return "", err
+	}
+	stream, err := newZipAESStream(password, strength, header[:saltSize], header[saltSize:])
+	if err != nil {
+		return "", err
+	}
+
+	dataOffset := saltSize + zipAESVerifier
+	dataSize := int64(entry.CompressedSize64) - dataOffset - zipAESAuthCode
+	if dataSize < 0 {
+		return "", errors.New("encrypted zip entry is truncated")
+	}
+
+	switch method {
+	case zip.Store:
+		if dataSize != fileSize {
+			return "", errors.New("encrypted zip entry has an unexpected size")
+		}
+		buf := make([]byte, OSDBChunkSize*2)
+		for i, offset := range []int64{0, fileSize - OSDBChunkSize} {
+			chunk := buf[int64(i)*OSDBChunkSize : int64(i+1)*OSDBChunkSize]
+			if _, err := rawReader.ReadAt(chunk, dataOffset+offset); err != nil {
+				return "", err
+			}
+			stream.seek(offset)
+			stream.XORKeyStream(chunk, chunk)
+		}
+		return osdbHash(fileSize, buf), nil
+	case zip.Deflate:
+		decrypted := &cipher.StreamReader{S: stream, R: io.NewSectionReader(rawReader, dataOffset, dataSize)}
+		inflated := flate.NewReader(decrypted)
+		defer inflated.Close()
+		return hashZipStream(inflated, fileSize)
+	}
+	return "", fmt.Errorf("unsupported zip compression method %v", method)
+}
+
+// Zip record signatures
+const (
+	zipLocalHeaderSignature     = 0x04034b50
+	zipDataDescriptorSignature  = 0x08074b50
+	zipCentralHeaderSignature   = 0x02014b50
+	zipEndOfDirectorySignature  = 0x06054b50
+	zipFlagEncrypted            = 1 << 0
+	zipFlagDataDescriptor       = 1 << 3
+	zipExtraZip64               = 0x0001
+	zipLocalHeaderSize          = 26
+	zipDataDescriptorSizeLegacy = 12
+	zipDataDescriptorSizeZip64  = 20
+)
+
+// Generate an OSDB hash for `entryName` in a zip archive read from `r`, such as a download in progress, without
+// needing the central directory at the end of the archive or random access. Local file headers are followed until
+// the entry is found, and it's inflated on the fly. Reading stops once the entry is hashed.
+//
+// `entrySize` is the uncompressed size of the entry, needed when the archive was written as a stream and its local
+// headers don't have sizes. It's checked against the header when it does, and can be 0 to use the header's size.
+// Entries before the one hashed can only be skipped when they're stored with their size or deflated.
+func OSDBHashZipStream(r io.Reader, entryName string, entrySize int64) (string, error) {
+	// `flate` reads byte by byte from an `io.ByteReader`, so it doesn't read past the end of compressed data.
+	br := bufio.NewReader(r)
+	for {
+		var signature uint32
+		if err := binary.Read(br, binary.LittleEndian, &signature); err != nil {
+			return "", fmt.Errorf("couldn't read zip stream: %w", err)
+		}
+		switch signature {
+		case zipLocalHeaderSignature:
+		case zipCentralHeaderSignature, zipEndOfDirectorySignature:
+			return "", fmt.Errorf("zip has no entry %s", entryName)
+		default:
+			return "", errors.New("zip stream is malformed")
+		}
+
+		header := make([]byte, zipLocalHeaderSize)
+		if _, err := io.ReadFull(br, header); err != nil {
+			return "", fmt.Errorf("couldn't read zip stream: %w", err)
+		}
+		flags := binary.LittleEndian.Uint16(header[2:])
+		method := binary.LittleEndian.Uint16(header[4:])
+		compressedSize := int64(binary.LittleEndian.Uint32(header[14:]))
+		size := int64(binary.LittleEndian.Uint32(header[18:]))
+		nameAndExtra := make([]byte, int(binary.LittleEndian.Uint16(header[22:]))+int(binary.LittleEndian.Uint16(header[24:])))
+		if _, err := io.ReadFull(br, nameAndExtra); err != nil {
+			return "", fmt.Errorf("couldn't read zip stream: %w", err)
+		}
+		name := string(nameAndExtra[:binary.LittleEndian.Uint16(header[22:])])
+		extra := nameAndExtra[len(name):]
+
+		// Zip64 extra has the sizes that didn't fit the header, uncompressed one first.
+		zip64 := false
+		for len(extra) >= 4 {
+			id, fieldSize := binary.LittleEndian.Uint16(extra), int(binary.LittleEndian.Uint16(extra[2:]))
+			field := extra[4:min(4+fieldSize, len(extra))]
+			extra = extra[len(field)+4:]
+			if id != zipExtraZip64 {
+				continue
+			}
+			zip64 = true
+			if size == 0xFFFFFFFF && len(field) >= 8 {
+				size, field = int64(binary.LittleEndian.Uint64(field)), field[8:]
+			}
+			if compressedSize == 0xFFFFFFFF && len(field) >= 8 {
+				compressedSize = int64(binary.LittleEndian.Uint64(field))
+			}
+		}
+		sizesKnown := flags&zipFlagDataDescriptor == 0
+
+		if name == entryName {
+			if flags&zipFlagEncrypted != 0 {
+				return "", errors.New("zip entry is encrypted, use OSDBHashEncryptedZip()")
+			}
+			if sizesKnown {
+				if entrySize > 0 && entrySize != size {
+					return "", fmt.Errorf("zip entry is %d bytes, not %d", size, entrySize)
+				}
+				entrySize = size
+			}
+			if entrySize < OSDBChunkSize {
+				return "", errors.New("zip entry is too small to generate a valid hash")
+			}
+
+			switch method {
+			case zip.Store:
+				return hashZipStream(io.LimitReader(br, entrySize), entrySize)
+			case zip.Deflate:
+				return hashZipStream(flate.NewReader(br), entrySize)
+			}
+			return "", fmt.Errorf("unsupported zip compression method %d", method)
+		}
+
+		// Skip over the entry, and its data descriptor
+		switch {
+		case sizesKnown:
+			if _, err := io.CopyN(io.Discard, br, compressedSize); err != nil {
+				return "", fmt.Errorf("couldn't read zip stream: %w", err)
+			}
+			continue
+		case method == zip.Deflate && flags&zipFlagEncrypted == 0:
+			n, err := io.Copy(io.Discard, flate.NewReader(br))
+			if err != nil {
+				return "", fmt.Errorf("couldn't inflate zip entry %s: %w", name, err)
+			}
+			// Streaming writers only find out the entry needs zip64 sizes once it's written.
+			zip64 = zip64 || n >= 0xFFFFFFFF
+		default:
+			return "", fmt.Errorf("can't skip zip entry %s without knowing its size", name)
+		}
+
+		descriptorSize := zipDataDescriptorSizeLegacy
+		if zip64 {
+			descriptorSize = zipDataDescriptorSizeZip64
+		}
+		descriptor, err := br.Peek(4)
+		if err != nil {
+			return "", fmt.Errorf("couldn't read zip stream: %w", err)
+		}
+		if binary.LittleEndian.Uint32(descriptor) == zipDataDescriptorSignature {
+			descriptorSize += 4
+		}
+		if _, err := br.Discard(descriptorSize); err != nil {
+			return "", fmt.Errorf("couldn't read zip stream: %w", err)
+		}
+	}
+}
+
+func hashZipStream(r io.Reader, fileSize int64) (string, error) {
+	writer := newHeadTailWriter(fileSize, OSDBChunkSize)
+	if _, err := io.Copy(writer, r); err != nil {
+		return "", err
+	}
+	buf, err := writer.chunks()
+	if err != nil {
+		return "", err
+	}
+	return osdbHash(fileSize, buf), nil
+}
+
+// AES key strength (1 to 3 for 128 to 256 bit keys), and the compression method of the encrypted data.
+func parseZipAESExtra(extra []byte) (strength int, method uint16, err error) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if 4+size > len(extra) {
+			break
+		}
+		if id == zipExtraAES && size >= 7 {
+			field := extra[4 : 4+size]
+			strength = int(field[4])
+			if strength < 1 || strength > 3 {
+				return 0, 0, fmt.Errorf("invalid zip AES strength %v", strength)
+			}
+			return strength, binary.LittleEndian.Uint16(field[5:7]), nil
+		}
+		extra = extra[4+size:]
+	}
+	return 0, 0, errors.New("encrypted zip entry is missing its AES extra field")
+}
+
+// AES in CTR mode with a little endian counter starting at 1, as WinZip does it.
+type zipAESStream struct {
+	block     cipher.Block
+	counter   uint64
+	keystream [aes.BlockSize]byte
+	used      int
+}
+
+func newZipAESStream(password string, strength int, salt, verifier []byte) (*zipAESStream, error) {
+	keySize := 8 + 8*strength
+	keys := pbkdf2.Key([]byte(password), salt, zipAESIteration, 2*keySize+zipAESVerifier, sha1.New)
+	if !bytes.Equal(keys[2*keySize:], verifier) {
+		return nil, ErrWrongPassword
+	}
+	block, err := aes.NewCipher(keys[:keySize])
+	if err != nil {
+		return nil, err
+	}
+	return &zipAESStream{block: block, used: aes.BlockSize}, nil
+}
+
+// Position the keystream at `offset` bytes into the encrypted data.
+func (s *zipAESStream) seek(offset int64) {
+	s.counter = uint64(offset / aes.BlockSize)
+	s.used = aes.BlockSize
+	if remainder := int(offset % aes.BlockSize); remainder != 0 {
+		s.next()
+		s.used = remainder
+	}
+}
+
+func (s *zipAESStream) next() {
+	s.counter++
+	var counter [aes.BlockSize]byte
+	binary.LittleEndian.PutUint64(counter[:], s.counter)
+	s.block.Encrypt(s.keystream[:], counter[:])
+	s.used = 0
+}
+
+func (s *zipAESStream) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if s.used == aes.BlockSize {
+			s.next()
+		}
+		dst[i] = src[i] ^ s.keystream[s.used]
+		s.used++
+	}
+}
@@ -0,0 +1,339 @@
+package lib
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"uosc/bins/src/ziggy/lib/testutil"
+)
+
+type zipTestEntry struct {
+	name string
+	data []byte
+	// Written with `CreateRaw()` and sizes in the local header, rather than streamed with a data descriptor.
+	raw    bool
+	method uint16
+	// WinZip AES strength, or 0 to leave the entry unencrypted.
+	strength int
+}
+
+const zipTestPassword = "hunter2"
+
+func writeTestZip(tb testing.TB, entries ...zipTestEntry) []byte {
+	tb.Helper()
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for _, entry := range entries {
+		var err error
+		switch {
+		case entry.strength > 0:
+			err = writeZipAESEntry(writer, entry)
+		case entry.raw:
+			err = writeRawZipEntry(writer, entry)
+		default:
+			var w io.Writer
+			if w, err = writer.CreateHeader(&zip.FileHeader{Name: entry.name, Method: entry.method}); err == nil {
+				_, err = w.Write(entry.data)
+			}
+		}
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return archive.Bytes()
+}
+
+func compressZipData(data []byte, method uint16) ([]byte, error) {
+	if method == zip.Store {
+		return data, nil
+	}
+	var compressed bytes.Buffer
+	w, err := flate.NewWriter(&compressed, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	return compressed.Bytes(), err
+}
+
+func writeRawZipEntry(writer *zip.Writer, entry zipTestEntry) error {
+	compressed, err := compressZipData(entry.data, entry.method)
+	if err != nil {
+		return err
+	}
+	w, err := writer.CreateRaw(&zip.FileHeader{
+		Name:               entry.name,
+		Method:             entry.method,
+		CRC32:              crc32.ChecksumIEEE(entry.data),
+		CompressedSize64:   uint64(len(compressed)),
+		UncompressedSize64: uint64(len(entry.data)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(compressed)
+	return err
+}
+
+// Encrypted as described in https://www.winzip.com/en/support/aes-encryption/, as AE-2 without a CRC.
+func writeZipAESEntry(writer *zip.Writer, entry zipTestEntry) error {
+	compressed, err := compressZipData(entry.data, entry.method)
+	if err != nil {
+		return err
+	}
+
+	keySize := 8 + 8*entry.strength
+	salt := bytes.Repeat([]byte{0x5A}, 4+4*entry.strength)
+	keys := pbkdf2.Key([]byte(zipTestPassword), salt, 1000, 2*keySize+2, sha1.New)
+	block, err := aes.NewCipher(keys[:keySize])
+	if err != nil {
+		return err
+	}
+	encrypted := make([]byte, len(compressed))
+	var counter, keystream [aes.BlockSize]byte
+	for offset := 0; offset < len(compressed); offset += aes.BlockSize {
+		binary.LittleEndian.PutUint64(counter[:], uint64(offset/aes.BlockSize+1))
+		block.Encrypt(keystream[:], counter[:])
+		for i := offset; i < min(offset+aes.BlockSize, len(compressed)); i++ {
+			encrypted[i] = compressed[i] ^ keystream[i-offset]
+		}
+	}
+	mac := hmac.New(sha1.New, keys[keySize:2*keySize])
+	mac.Write(encrypted)
+
+	data := append(append(append(salt, keys[2*keySize:]...), encrypted...), mac.Sum(nil)[:10]...)
+	extra := binary.LittleEndian.AppendUint16(nil, zipExtraAES)
+	extra = binary.LittleEndian.AppendUint16(extra, 7)
+	extra = binary.LittleEndian.AppendUint16(extra, 2)
+	extra = append(extra, 'A', 'E', byte(entry.strength))
+	extra = binary.LittleEndian.AppendUint16(extra, entry.method)
+
+	w, err := writer.CreateRaw(&zip.FileHeader{
+		Name:               entry.name,
+		Method:             zipMethodAES,
+		Flags:              zipFlagEncrypted,
+		Extra:              extra,
+		CompressedSize64:   uint64(len(data)),
+		UncompressedSize64: uint64(len(entry.data)),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func TestOSDBHashZipStream(t *testing.T) {
+	const size = 1 << 20
+	video := readSyntheticFile(t, size)
+	subtitles := bytes.Repeat([]byte("1\n00:00:01,000 --> 00:00:02,000\nHello\n\n"), 100)
+
+	tests := []struct {
+		name      string
+		entries   []zipTestEntry
+		entrySize int64
+		wantErr   bool
+	}{
+		{
+			name: "stored with sizes",
+			entries: []zipTestEntry{
+				{name: "movie.srt", data: subtitles, raw: true, method: zip.Store},
+				{name: "movie.mkv", data: video, raw: true, method: zip.Store},
+			},
+		},
+		{
+			name: "deflated with sizes",
+			entries: []zipTestEntry{
+				{name: "movie.srt", data: subtitles, raw: true, method: zip.Deflate},
+				{name: "movie.mkv", data: video, raw: true, method: zip.Deflate},
+			},
+			entrySize: size,
+		},
+		{
+			name: "streamed after a streamed entry",
+			entries: []zipTestEntry{
+				{name: "movie.srt", data: subtitles, method: zip.Deflate},
+				{name: "movie.mkv", data: video, method: zip.Deflate},
+			},
+			entrySize: size,
+		},
+		{
+			name: "streamed stored entry",
+			entries: []zipTestEntry{
+				{name: "movie.mkv", data: video, method: zip.Store},
+			},
+			entrySize: size,
+		},
+		{
+			name: "streamed without entry size",
+			entries: []zipTestEntry{
+				{name: "movie.mkv", data: video, method: zip.Deflate},
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong entry size",
+			entries: []zipTestEntry{
+				{name: "movie.mkv", data: video, raw: true, method: zip.Store},
+			},
+			entrySize: size - 1,
+			wantErr:   true,
+		},
+		{
+			name: "after a streamed stored entry",
+			entries: []zipTestEntry{
+				{name: "movie.srt", data: subtitles, method: zip.Store},
+				{name: "movie.mkv", data: video, raw: true, method: zip.Store},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing entry",
+			entries: []zipTestEntry{
+				{name: "movie.srt", data: subtitles, raw: true, method: zip.Store},
+			},
+			wantErr: true,
+		},
+		{
+			name: "encrypted",
+			entries: []zipTestEntry{
+				{name: "movie.mkv", data: video, method: zip.Store, strength: 3},
+			},
+			wantErr: true,
+		},
+		{
+			name: "too small",
+			entries: []zipTestEntry{
+				{name: "movie.mkv", data: subtitles, raw: true, method: zip.Store},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := writeTestZip(t, tt.entries...)
+			// No random access, and short reads
+			hash, err := OSDBHashZipStream(iotest.HalfReader(bytes.NewReader(archive)), "movie.mkv", tt.entrySize)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("succeeded with %s", hash)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := testutil.KnownHash(size); hash != expected {
+				t.Errorf("hash is %s, expected %s", hash, expected)
+			}
+		})
+	}
+}
+
+func TestOSDBHashEncryptedZip(t *testing.T) {
+	const size = 1<<20 + 5
+	video := readSyntheticFile(t, size)
+
+	tests := []struct {
+		name     string
+		entry    zipTestEntry
+		password string
+		err      error
+		wantErr  bool
+	}{
+		{name: "stored AES-128", entry: zipTestEntry{data: video, method: zip.Store, strength: 1}},
+		{name: "stored AES-192", entry: zipTestEntry{data: video, method: zip.Store, strength: 2}},
+		{name: "stored AES-256", entry: zipTestEntry{data: video, method: zip.Store, strength: 3}},
+		{name: "deflated AES-256", entry: zipTestEntry{data: video, method: zip.Deflate, strength: 3}},
+		{name: "unencrypted", entry: zipTestEntry{data: video, method: zip.Deflate}, password: "ignored"},
+		{
+			name:     "wrong password",
+			entry:    zipTestEntry{data: video, method: zip.Store, strength: 3},
+			password: "hunter3",
+			err:      ErrWrongPassword,
+		},
+		{name: "too small", entry: zipTestEntry{data: video[:OSDBChunkSize-1], method: zip.Store, strength: 3}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.entry.name = "movie.mkv"
+			path := filepath.Join(t.TempDir(), "movie.zip")
+			if err := os.WriteFile(path, writeTestZip(t, tt.entry), 0666); err != nil {
+				t.Fatal(err)
+			}
+			if tt.password == "" {
+				tt.password = zipTestPassword
+			}
+
+			hash, err := OSDBHashEncryptedZip(path, "movie.mkv", tt.password)
+			if tt.err != nil || tt.wantErr {
+				if err == nil || (tt.err != nil && !errors.Is(err, tt.err)) {
+					t.Errorf("hash is %s and error %v, expected error %v", hash, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := testutil.KnownHash(size); hash != expected {
+				t.Errorf("hash is %s, expected %s", hash, expected)
+			}
+		})
+	}
+
+	t.Run("missing entry", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "movie.zip")
+		archive := writeTestZip(t, zipTestEntry{name: "movie.mkv", data: video, method: zip.Store, strength: 1})
+		if err := os.WriteFile(path, archive, 0666); err != nil {
+			t.Fatal(err)
+		}
+		if hash, err := OSDBHashEncryptedZip(path, "other.mkv", zipTestPassword); err == nil {
+			t.Errorf("succeeded with %s", hash)
+		}
+	})
+}
+
+func TestZipAESStreamSeek(t *testing.T) {
+	salt := bytes.Repeat([]byte{1}, 16)
+	keys := pbkdf2.Key([]byte(zipTestPassword), salt, zipAESIteration, 2*32+zipAESVerifier, sha1.New)
+
+	data := make([]byte, 1000)
+	whole, err := newZipAESStream(zipTestPassword, 3, salt, keys[64:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	whole.XORKeyStream(data, data)
+
+	for _, offset := range []int64{0, 1, 15, 16, 17, 500, 999} {
+		stream, err := newZipAESStream(zipTestPassword, 3, salt, keys[64:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.seek(offset)
+		part := make([]byte, 1000-offset)
+		stream.XORKeyStream(part, part)
+		if !bytes.Equal(part, data[offset:]) {
+			t.Errorf("keystream after seeking to %d doesn't match", offset)
+		}
+	}
+}
@@ -27,6 +27,12 @@ func main() {
 	case "set-clipboard":
 		commands.SetClipboard(args)
 
+	case "verify-hashes":
+		commands.VerifyHashes(args)
+
+	case "version":
+		commands.Version(args)
+
 	default:
 		panic(errors.New("command required"))
 	}
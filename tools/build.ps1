@@ -45,6 +45,10 @@ elseif ($args[0] -eq "ziggy") {
 	$env:GOARCH = "amd64"
 	$Src = "./src/ziggy/ziggy.go"
 	$OutDir = "./src/uosc/bin"
+	$Lib = "uosc/bins/src/ziggy/lib"
+	$GitCommit = git rev-parse --short HEAD
+	$BuildDate = (Get-Date).ToUniversalTime().ToString("yyyy-MM-ddTHH:mm:ssZ")
+	$LdFlags = "-s -w -X $Lib.gitCommit=$GitCommit -X $Lib.buildDate=$BuildDate"
 
 	if (!(Test-Path $OutDir)) {
 		New-Item -ItemType Directory -Force -Path $OutDir > $null
@@ -52,15 +56,15 @@ elseif ($args[0] -eq "ziggy") {
 
 	Write-Output "Building for Windows..."
 	$env:GOOS = "windows"
-	go build -ldflags "-s -w" -o "$OutDir/ziggy-windows.exe" $Src
+	go build -ldflags $LdFlags -o "$OutDir/ziggy-windows.exe" $Src
 
 	Write-Output "Building for Linux..."
 	$env:GOOS = "linux"
-	go build -ldflags "-s -w" -o "$OutDir/ziggy-linux" $Src
+	go build -ldflags $LdFlags -o "$OutDir/ziggy-linux" $Src
 
 	Write-Output "Building for MacOS..."
 	$env:GOOS = "darwin"
-	go build -ldflags "-s -w" -o "$OutDir/ziggy-darwin" $Src
+	go build -ldflags $LdFlags -o "$OutDir/ziggy-darwin" $Src
 
 	if ($args[1] -eq "-c") {
 		Write-Output "Compressing binaries..."